@@ -0,0 +1,181 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"spotify-live-lyricist/pkg/lyricTreeSet"
+	"sync"
+	"time"
+)
+
+// requireAdmin looks up the Spotify user behind the session and checks
+// it against the admin allow-list. Like getClient, it writes the error
+// response itself, so the caller handler only needs to return when ok is false.
+func (a *App) requireAdmin(w http.ResponseWriter, r *http.Request) (string, bool) {
+	_, user, err := a.getUser(w, r)
+	if err != nil {
+		return "", false
+	}
+
+	if !a.admins[user.ID] {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return "", false
+	}
+
+	return user.ID, true
+}
+
+const recentErrorsLimit = 50
+
+type errorEntry struct {
+	Time            time.Time
+	Source, Message string
+}
+
+// sessionEntry is a session on the dashboard. Session IDs are the
+// cookies users log in with, so the page only shows their handle.
+type sessionEntry struct {
+	Handle       string
+	LastActivity time.Time
+}
+
+// sessionHandle stands for a session on the dashboard: an HMAC of its
+// ID, which can't be turned back into the ID.
+func (a *App) sessionHandle(id string) string {
+	mac := hmac.New(sha256.New, []byte("session handle:"+a.cfg.EncryptionKey))
+	mac.Write([]byte(id))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+type dashboard struct {
+	CacheSize, CacheLimit int
+	Hits, Misses          int
+	HitRatio              float64
+	CacheEntries          []lyricTreeSet.Entry
+	Sessions              []sessionEntry
+	Providers             []providerStats
+	Errors                []errorEntry
+}
+
+// errorLog keeps the last recentErrorsLimit errors for the dashboard.
+type errorLog struct {
+	sync.Mutex
+	entries []errorEntry
+}
+
+func newErrorLog() *errorLog {
+	return &errorLog{}
+}
+
+func (l *errorLog) record(source string, err error) {
+	l.Lock()
+	defer l.Unlock()
+
+	l.entries = append(l.entries, errorEntry{time.Now(), source, err.Error()})
+	if len(l.entries) > recentErrorsLimit {
+		l.entries = l.entries[1:]
+	}
+}
+
+func (l *errorLog) list() []errorEntry {
+	l.Lock()
+	defer l.Unlock()
+
+	// newest first
+	entries := make([]errorEntry, len(l.entries))
+	for i, e := range l.entries {
+		entries[len(entries)-1-i] = e
+	}
+	return entries
+}
+
+func (a *App) adminDashboard(w http.ResponseWriter, r *http.Request) {
+	if _, ok := a.requireAdmin(w, r); !ok {
+		return
+	}
+
+	d := dashboard{
+		Providers: a.lyrics.providerStats(),
+		Errors:    a.errors.list(),
+	}
+
+	c := a.lyrics.cache
+	c.mutex.Lock()
+	d.CacheSize = c.lSet.Size()
+	d.CacheLimit = c.lSet.Limit()
+	d.CacheEntries = c.lSet.Entries()
+	d.Hits, d.Misses = c.hits, c.misses
+	c.mutex.Unlock()
+	if d.Hits+d.Misses > 0 {
+		d.HitRatio = float64(d.Hits) / float64(d.Hits+d.Misses) * 100
+	}
+
+	ids, err := a.store.SessionIDs(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	sessions, err := a.store.GetSessions(r.Context(), ids)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for _, id := range ids {
+		s, ok := sessions[id]
+		if !ok {
+			continue // expired between SCAN and MGET
+		}
+		d.Sessions = append(d.Sessions, sessionEntry{a.sessionHandle(id), s.LastActivity})
+	}
+
+	err = a.tpl.ExecuteTemplate(w, "admin.gohtml", d)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (a *App) evictCacheEntry(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if _, ok := a.requireAdmin(w, r); !ok {
+		return
+	}
+
+	a.lyrics.evict(r.FormValue("artist"), r.FormValue("title"))
+
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+func (a *App) revokeSession(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if _, ok := a.requireAdmin(w, r); !ok {
+		return
+	}
+
+	ids, err := a.store.SessionIDs(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	handle := []byte(r.FormValue("handle"))
+	for _, id := range ids {
+		if !hmac.Equal([]byte(a.sessionHandle(id)), handle) {
+			continue
+		}
+		if err := a.store.DeleteSession(r.Context(), id); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, "/admin", http.StatusSeeOther)
+		return
+	}
+
+	http.Error(w, "Session not found", http.StatusNotFound)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"spotify-live-lyricist/pkg/fakeSpotify"
+)
+
+func TestSessionCookies(t *testing.T) {
+	app, _, _ := newTestApp(t)
+
+	state := httptest.NewRecorder()
+	app.initAuth(state, httptest.NewRequest("GET", "/authenticate", nil))
+	login := httptest.NewRecorder()
+	if _, err := app.createSession(context.Background(), login, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	cookies := append(state.Result().Cookies(), login.Result().Cookies()...)
+	if len(cookies) != 2 {
+		t.Fatalf("got cookies %v", cookies)
+	}
+	for _, c := range cookies {
+		if !c.HttpOnly || c.SameSite != http.SameSiteLaxMode {
+			t.Errorf("%s: HttpOnly %v, SameSite %v", c.Name, c.HttpOnly, c.SameSite)
+		}
+	}
+}
+
+func TestAdminDashboard(t *testing.T) {
+	app, fake, srv := newTestApp(t)
+	c := loggedIn(t, fake, srv)
+	ctx := context.Background()
+
+	if resp, _ := get(t, c, srv.URL+"/admin"); resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("not an admin: got %d", resp.StatusCode)
+	}
+	app.admins[fakeSpotify.DefaultUser] = true
+
+	fake.Play(fakeSpotify.DefaultUser, fakeSpotify.Track("t1", "Artist", "Song", 3*time.Minute))
+	get(t, c, srv.URL+"/")
+	app.store.SetSession(ctx, "other", session{LastActivity: time.Now()})
+
+	_, body := get(t, c, srv.URL+"/admin")
+	handle := app.sessionHandle("other")
+	for _, want := range []string{"Sessions (2 active)", handle, "<td>Artist</td><td>Song</td>"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("dashboard lacks %q\n%s", want, body)
+		}
+	}
+	if strings.Contains(body, `"other"`) {
+		t.Errorf("dashboard shows a session ID")
+	}
+
+	tests := []struct {
+		name   string
+		path   string
+		form   url.Values
+		status int
+	}{
+		{"revoke", "/admin/sessions/revoke", url.Values{"handle": {handle}}, http.StatusSeeOther},
+		{"revoke again", "/admin/sessions/revoke", url.Values{"handle": {handle}}, http.StatusNotFound},
+		{"revoke by ID", "/admin/sessions/revoke", url.Values{"handle": {"other"}}, http.StatusNotFound},
+		{"evict", "/admin/cache/evict", url.Values{"artist": {"Artist"}, "title": {"Song"}}, http.StatusSeeOther},
+	}
+	for _, tt := range tests {
+		if resp := post(t, c, srv.URL+tt.path, tt.form); resp.StatusCode != tt.status {
+			t.Errorf("%s: got %d, want %d", tt.name, resp.StatusCode, tt.status)
+		}
+	}
+
+	if _, err := app.store.GetSession(ctx, "other"); err != errNotFound {
+		t.Errorf("revoked session: got %v", err)
+	}
+	if app.lyrics.cached("Artist", "Song") {
+		t.Errorf("evicted lyrics still cached")
+	}
+	if resp, _ := get(t, c, srv.URL+"/admin/cache/evict"); resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("GET evict: got %d", resp.StatusCode)
+	}
+}
+
+func TestGetSessions(t *testing.T) {
+	store := newMemoryStore()
+	ctx := context.Background()
+	store.SetSession(ctx, "a", session{Clean: cleanMode{Mask: true}})
+	store.SetSession(ctx, "b", session{})
+
+	sessions, err := store.GetSessions(ctx, []string{"a", "missing", "b"})
+	if err != nil || len(sessions) != 2 || !sessions["a"].Clean.Mask {
+		t.Errorf("got %+v, %v", sessions, err)
+	}
+}
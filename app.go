@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"spotify-live-lyricist/pkg/artTheme"
+	"spotify-live-lyricist/pkg/config"
+	"spotify-live-lyricist/pkg/profanity"
+	"spotify-live-lyricist/pkg/staticAssets"
+	"sync"
+)
+
+// App serves the lyrics site. Everything a handler needs
+// hangs off it, so several apps can run in one process
+// and tests can swap the backends for fakes.
+type App struct {
+	cfg      *config.Config
+	logger   *slog.Logger
+	tpl      *pages
+	static   *staticAssets.Assets // served under /public/
+	store    Store
+	lyrics   *lyricsService
+	spotify  *spotifyFactory
+	metrics  *metrics
+	errors   *errorLog
+	cleaner  *profanity.Filter // masks lyrics in clean mode
+	players  *pollerHub
+	prefetch *prefetcher
+	exports  *exportJobs
+	themes   *themeCache
+	admins   map[string]bool // Spotify user IDs allowed into /admin
+
+	// draining is closed once the server starts shutting down. Long-lived
+	// responses such as event streams select on it so that they end before
+	// the shutdown timeout instead of being cut off.
+	draining     chan struct{}
+	drainingOnce sync.Once
+
+	historyMutex sync.Mutex // serializes merging plays into histories
+}
+
+// Deps are the backends an App talks to.
+type Deps struct {
+	Logger    *slog.Logger
+	Files     fs.FS // templates/ and public/; appFiles if nil
+	Store     Store
+	Lyrics    *lyricsService
+	Spotify   *spotifyFactory
+	Metrics   *metrics
+	Errors    *errorLog
+	Profanity *profanity.Filter // the default word lists if nil
+}
+
+func NewApp(cfg *config.Config, deps Deps) *App {
+	a := &App{
+		cfg:      cfg,
+		logger:   deps.Logger,
+		store:    deps.Store,
+		lyrics:   deps.Lyrics,
+		spotify:  deps.Spotify,
+		metrics:  deps.Metrics,
+		errors:   deps.Errors,
+		admins:   make(map[string]bool),
+		draining: make(chan struct{}),
+		exports:  newExportJobs(),
+		themes:   &themeCache{byArt: make(map[string]artTheme.Theme), fetching: make(map[string]bool)},
+	}
+	files := deps.Files
+	if files == nil {
+		files = appFiles(cfg.DevMode)
+	}
+	public, err := fs.Sub(files, "public")
+	if err != nil {
+		panic(err) // only for invalid names
+	}
+	a.static = staticAssets.Must(staticAssets.New(public, cfg.DevMode))
+	a.tpl = newPages(files, a.static, cfg.DevMode)
+
+	if a.cleaner = deps.Profanity; a.cleaner == nil {
+		a.cleaner = profanity.New(profanity.Defaults)
+	}
+	a.players = newPollerHub(a.pollPlayer, a.recordPlaying)
+	a.prefetch = newPrefetcher(a.lyrics, a.metrics, a.startSpotifyCall, cfg.PrefetchAhead, cfg.PrefetchWorkers)
+	for _, id := range cfg.AdminIDs {
+		a.admins[id] = true
+	}
+	return a
+}
+
+// Handler routes requests to the handlers, behind the middleware.
+func (a *App) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", a.playerHandler)
+	mux.HandleFunc("/authenticate", a.initAuth)
+	mux.HandleFunc("/callback", a.completeAuth)
+	mux.HandleFunc("/logout", a.logout)
+	mux.HandleFunc("/now-playing", a.nowPlayingEvents)
+	mux.HandleFunc("/healthz", a.healthz)
+	mux.HandleFunc("/readyz", a.readyz)
+	mux.HandleFunc("/print", a.printPage)
+	mux.HandleFunc("/print.pdf", a.printPDF)
+	mux.HandleFunc("/search", a.searchPage)
+	mux.HandleFunc("/search/play", a.playSearchHit)
+	mux.HandleFunc("/api/v1/lyrics", a.lyricsAPI)
+	mux.HandleFunc("/api/v1/search", a.searchAPI)
+	mux.HandleFunc("/api/v1/seek-to-line", a.seekToLine)
+	mux.HandleFunc("/history", a.historyPage)
+	mux.HandleFunc("/stats", a.statsPage)
+	mux.HandleFunc("/settings/clean", a.cleanSettings)
+	mux.HandleFunc("/export", a.exportPage)
+	mux.HandleFunc("/export/status", a.exportStatusHandler)
+	mux.HandleFunc("/export/download", a.exportDownload)
+	mux.HandleFunc("/corrections/new", a.correctionForm)
+	mux.HandleFunc("/corrections", a.submitCorrection)
+	mux.HandleFunc("/admin", a.adminDashboard)
+	mux.HandleFunc("/admin/cache/evict", a.evictCacheEntry)
+	mux.HandleFunc("/admin/sessions/revoke", a.revokeSession)
+	mux.HandleFunc("/admin/revisions", a.moderationQueue)
+	mux.HandleFunc("/admin/revisions/moderate", a.moderateRevisionHandler)
+	mux.Handle("/metrics", a.metrics.handler())
+	mux.Handle("/public/", http.StripPrefix("/public/", a.static))
+	mux.Handle("/favicon.ico", http.NotFoundHandler())
+
+	return a.requestIDMiddleware(tracingMiddleware(mux, a.metrics.middleware(mux, a.authMiddleware(mux))))
+}
+
+// Run serves until ctx is cancelled, then stops accepting connections
+// and waits up to the shutdown timeout for in-flight requests.
+func (a *App) Run(ctx context.Context) error {
+	srv := &http.Server{
+		Addr:              fmt.Sprintf(":%d", a.cfg.Port),
+		Handler:           a.Handler(),
+		ReadHeaderTimeout: a.cfg.ReadHeaderTimeout,
+		ReadTimeout:       a.cfg.ReadTimeout,
+		WriteTimeout:      a.cfg.WriteTimeout,
+		IdleTimeout:       a.cfg.IdleTimeout,
+	}
+	srv.RegisterOnShutdown(a.startDraining)
+	go a.saveSearchIndex(ctx)
+
+	drained := make(chan error, 1)
+	go func() {
+		<-ctx.Done()
+		a.logger.Info("Shutting down", "timeout", a.cfg.ShutdownTimeout)
+		ctx, cancel := context.WithTimeout(context.Background(), a.cfg.ShutdownTimeout)
+		defer cancel()
+		drained <- srv.Shutdown(ctx)
+	}()
+
+	a.logger.Info("Listening", "port", a.cfg.Port)
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+	return <-drained
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"spotify-live-lyricist/pkg/config"
+	"spotify-live-lyricist/pkg/fakeSpotify"
+	"spotify-live-lyricist/pkg/lyricSearch"
+)
+
+// fakeFetcher serves the lyrics of "artist/title", "lyrics of <title>"
+// for other tracks, and none for those of Nobody.
+type fakeFetcher map[string]string
+
+func (f fakeFetcher) Fetch(artist, title string) string {
+	if lyrics, ok := f[artist+"/"+title]; ok {
+		return lyrics
+	}
+	if artist == "Nobody" {
+		return ""
+	}
+	return "lyrics of " + title + "\nline & two"
+}
+
+var testLyrics = fakeFetcher{
+	"Artist/Song": "la la la\nsecond line",
+	"Sync/Song":   "[ar:Sync]\n[offset:500]\n[00:01.00]first verse\n[00:10.5][01:00.00]chorus lala\nuntimed",
+}
+
+// newTestApp serves an app backed by the memory store, a fake lyrics
+// provider and a fake Spotify.
+func newTestApp(t *testing.T) (*App, *fakeSpotify.Server, *httptest.Server) {
+	fake := fakeSpotify.New()
+	t.Cleanup(fake.Close)
+
+	cfg := &config.Config{
+		SpotifyID:       "id",
+		SpotifySecret:   "secret",
+		EncryptionKey:   "key",
+		Store:           "memory",
+		PrefetchAhead:   5,
+		PrefetchWorkers: 2,
+	}
+	m := newMetrics()
+	store := newMemoryStore()
+	errs := newErrorLog()
+	index, err := lyricSearch.Open("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := NewApp(cfg, Deps{
+		Logger:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+		Store:   store,
+		Lyrics:  newLyricsService([]lyricProvider{{"fake", testLyrics}}, nil, store, index, m, errs),
+		Spotify: newSpotifyFactory("", cfg.SpotifyID, cfg.SpotifySecret, fake.Client(), m),
+		Metrics: m,
+		Errors:  errs,
+	})
+
+	srv := httptest.NewServer(app.Handler())
+	t.Cleanup(srv.Close)
+	app.spotify.oauth.RedirectURL = srv.URL + "/callback"
+	return app, fake, srv
+}
+
+// loggedIn returns a client logged in as the fake's DefaultUser, by
+// following the redirects of /authenticate through Spotify.
+func loggedIn(t *testing.T, fake *fakeSpotify.Server, srv *httptest.Server) *http.Client {
+	t.Helper()
+	jar, _ := cookiejar.New(nil)
+	c := &http.Client{Jar: jar, Transport: fake.Transport()}
+	get(t, c, srv.URL+"/authenticate")
+	return c
+}
+
+func get(t *testing.T, c *http.Client, url string) (*http.Response, string) {
+	t.Helper()
+	resp, err := c.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, string(body)
+}
+
+func TestPlayerHandler(t *testing.T) {
+	_, fake, srv := newTestApp(t)
+	c := loggedIn(t, fake, srv)
+
+	fake.Play(fakeSpotify.DefaultUser, fakeSpotify.Track("t1", "Artist", "Song", 3*time.Minute))
+	resp, body := get(t, c, srv.URL+"/")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("got %d\n%s", resp.StatusCode, body)
+	}
+	for _, want := range []string{"<strong>Song</strong>", "Artist", "la la la", "second line", "/ 3:00"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("player page lacks %q", want)
+		}
+	}
+
+	fake.Fail("/v1/me/player", http.StatusInternalServerError, 3)
+	if resp, body = get(t, c, srv.URL+"/"); resp.StatusCode != http.StatusBadGateway {
+		t.Errorf("failing Spotify: got %d, want 502\n%s", resp.StatusCode, body)
+	}
+}
+
+func TestLyricsAPI(t *testing.T) {
+	_, fake, srv := newTestApp(t)
+	c := loggedIn(t, fake, srv)
+	fake.Play(fakeSpotify.DefaultUser, fakeSpotify.Track("t1", "Sync", "Song", 2*time.Minute))
+
+	type lyrics struct {
+		Artist, Title string
+		Found, Synced bool
+		Lyrics        string
+		Source        string
+	}
+	tests := []struct {
+		query string
+		want  lyrics
+	}{
+		{"", lyrics{Artist: "Sync", Title: "Song", Found: true, Synced: true, Lyrics: "first verse\nchorus lala\nuntimed", Source: "fake"}},
+		{"?artist=Artist&title=Song", lyrics{Artist: "Artist", Title: "Song", Found: true, Lyrics: "la la la\nsecond line", Source: "fake"}},
+		{"?artist=Nobody&title=Missing", lyrics{Artist: "Nobody", Title: "Missing"}},
+	}
+	for _, tt := range tests {
+		resp, body := get(t, c, srv.URL+"/api/v1/lyrics"+tt.query)
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("%q: got %d\n%s", tt.query, resp.StatusCode, body)
+			continue
+		}
+		var got lyrics
+		if err := json.Unmarshal([]byte(body), &got); err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("%q: got %+v, want %+v", tt.query, got, tt.want)
+		}
+	}
+
+	if resp, _ := get(t, c, srv.URL+"/api/v1/lyrics?artist=Artist"); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("artist alone: got %d, want 400", resp.StatusCode)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"embed"
+	"html/template"
+	"io"
+	"io/fs"
+	"os"
+	"spotify-live-lyricist/pkg/staticAssets"
+)
+
+// embedded holds the templates and static files, so the binary runs
+// from any directory.
+//
+//go:embed templates public
+var embedded embed.FS
+
+// appFiles returns the templates and static files: those in the working
+// directory in dev mode, so edits show without a rebuild, and the
+// embedded ones otherwise.
+func appFiles(devMode bool) fs.FS {
+	if devMode {
+		return os.DirFS(".")
+	}
+	return embedded
+}
+
+// pages renders the templates, parsed once or, when they reload, again
+// for every page.
+type pages struct {
+	files  fs.FS
+	funcs  template.FuncMap
+	reload bool
+	tpl    *template.Template
+}
+
+func newPages(files fs.FS, static *staticAssets.Assets, reload bool) *pages {
+	p := &pages{files: files, reload: reload, funcs: template.FuncMap{
+		// {{asset "main.css"}} links a static file by its hashed name
+		"asset": func(name string) (string, error) {
+			path, err := static.Path(name)
+			return "/public/" + path, err
+		},
+	}}
+	if !reload {
+		p.tpl = template.Must(p.parse())
+	}
+	return p
+}
+
+func (p *pages) parse() (*template.Template, error) {
+	return template.New("").Funcs(p.funcs).ParseFS(p.files, "templates/*.gohtml")
+}
+
+func (p *pages) current() (*template.Template, error) {
+	if p.reload {
+		return p.parse()
+	}
+	return p.tpl, nil
+}
+
+// ExecuteTemplate renders the named template to w.
+func (p *pages) ExecuteTemplate(w io.Writer, name string, data interface{}) error {
+	tpl, err := p.current()
+	if err != nil {
+		return err
+	}
+	return tpl.ExecuteTemplate(w, name, data)
+}
+
+// Lookup returns the named template, or nil if there is none or the
+// templates don't parse.
+func (p *pages) Lookup(name string) *template.Template {
+	tpl, err := p.current()
+	if err != nil {
+		return nil
+	}
+	return tpl.Lookup(name)
+}
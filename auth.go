@@ -1,106 +1,148 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"github.com/gomodule/redigo/redis"
 	"github.com/satori/go.uuid"
 	"github.com/zmb3/spotify"
 	"golang.org/x/oauth2"
 	"net/http"
 	"spotify-live-lyricist/pkg/encrypt"
+	"strings"
 	"time"
 )
 
 type session struct {
 	Token        []byte
 	LastActivity time.Time
+	Profile      *profile
+	Clean        cleanMode
 }
 
-const sessionLength	= 900	// 30 mins
+// profile is the part of the user's Spotify profile shown on pages.
+// It is kept in the session so that pages don't call /me every time.
+type profile struct {
+	ID, DisplayName  string
+	AvatarURL        string
+	Country, Product string
+	Fetched          time.Time
+}
 
-var (
-	states      = make(map[string]string)	// sID, oauth state
-	sessions    = make(map[string]session)	// sessionId, session
-	lastSessionsCleaned time.Time
-)
+const sessionLength	= 900	// 30 mins
+const profileTTL	= time.Hour	// how long a session's profile is trusted
+
+// Paths served without a session.
+var publicPaths = map[string]bool{
+	"/authenticate":	true,
+	"/callback":		true,
+	"/metrics":			true,
+	"/healthz":			true,
+	"/readyz":			true,
+}
 
-func authMiddleware(next http.Handler) http.Handler {
+func (a *App) authMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func (w http.ResponseWriter, req *http.Request) {
-		if req.URL.Path != "/authenticate" && req.URL.Path != "/callback" {
+		if !publicPaths[req.URL.Path] && !strings.HasPrefix(req.URL.Path, "/public/") {
 			c, err := req.Cookie("session")
 			if err != nil {
 				http.Redirect(w, req, "/authenticate", http.StatusSeeOther)
 				return
 			}
 
-			s, err := getSessionFromRedis(c.Value)
+			s, err := a.store.GetSession(req.Context(), c.Value)
 			if err == nil {
 				s.LastActivity = time.Now()
-				err := setSession(c.Value, *s)
+				err := a.store.SetSession(req.Context(), c.Value, *s)
 				if err != nil {
+					reqLogger(req).Error("Refreshing session", "err", err)
+					a.errors.record("store", err)
 					http.Error(w, err.Error(), http.StatusInternalServerError)
 					return
 				}
-			} else if err == redis.ErrNil {
+			} else if err == errNotFound {
 				http.Redirect(w, req, "/authenticate", http.StatusSeeOther)
 				return
 			} else {
+				reqLogger(req).Error("Getting session", "err", err)
+				a.errors.record("store", err)
 				http.Error(w, err.Error(), http.StatusInternalServerError)
 				return
 			}
 
 			// refresh session
-			c.MaxAge = sessionLength
-			http.SetCookie(w, c)
+			http.SetCookie(w, newCookie("session", c.Value))
 		}
 
 		next.ServeHTTP(w, req)
 	})
 }
 
-func initAuth(w http.ResponseWriter, r *http.Request) {
+// newCookie returns a session or OAuth state cookie. Scripts can't read
+// it, and browsers leave it out of cross-site POSTs, so another site
+// can't make a logged in user, or admin, submit a form here.
+func newCookie(name, value string) *http.Cookie {
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		MaxAge:   sessionLength,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+func (a *App) initAuth(w http.ResponseWriter, r *http.Request) {
 	// create cookie for oauth state
 	sID, _ := uuid.NewV4()
 	state, _ := uuid.NewV4()
-	c := &http.Cookie{
-		Name:  "sID",
-		Value: sID.String(),
-	}
-	c.MaxAge = sessionLength
-	http.SetCookie(w, c)
+	http.SetCookie(w, newCookie("sID", sID.String()))
 
-	url := spotifyAuth.AuthURL(state.String())
-	states[sID.String()] = state.String()
+	if err := a.store.SetState(r.Context(), sID.String(), state.String()); err != nil {
+		reqLogger(r).Error("Saving OAuth state", "err", err)
+		a.errors.record("store", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	url := a.spotify.AuthURL(state.String())
 
 	http.Redirect(w, r, url, http.StatusTemporaryRedirect)
 }
 
-func completeAuth(w http.ResponseWriter, r *http.Request) {
-	tok, err := checkStateAndGetToken(w, r)
+func (a *App) completeAuth(w http.ResponseWriter, r *http.Request) {
+	tok, err := a.checkStateAndGetToken(w, r)
 	if err != nil {
-		fmt.Print(err)
+		reqLogger(r).Warn("Completing auth", "err", err)
+		a.errors.record("auth", err)
 		return
 	}
 
-	encToken, err := marshalAndEncryptToken(w, tok)
+	encToken, err := a.marshalAndEncryptToken(w, tok)
 	if err != nil {
+		reqLogger(r).Error("Encrypting token", "err", err)
 		http.Error(w, fmt.Sprintf("Token Error: %s", err.Error()), http.StatusInternalServerError)
 		return
 	}
 
-	_, err = createSession(w, encToken)
+	// without a profile the session still works, the next page fetches it
+	p, err := a.fetchProfile(r.Context(), a.spotify.NewClient(r.Context(), tok))
 	if err != nil {
+		reqLogger(r).Warn("Getting profile", "err", err)
+	}
+
+	_, err = a.createSession(r.Context(), w, encToken, p)
+	if err != nil {
+		reqLogger(r).Error("Creating session", "err", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
-	//fmt.Println("Successfully authenticated")
+	reqLogger(r).Info("Successfully authenticated")
 	http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
 }
 
 // Looks for sID cookie (which represents oauth state), checks if it matched, get token and deletes state
-func checkStateAndGetToken(w http.ResponseWriter, r *http.Request) (*oauth2.Token, error) {
+func (a *App) checkStateAndGetToken(w http.ResponseWriter, r *http.Request) (*oauth2.Token, error) {
 	sID, err := r.Cookie("sID")
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -108,13 +150,17 @@ func checkStateAndGetToken(w http.ResponseWriter, r *http.Request) (*oauth2.Toke
 	}
 
 	// delete cookie from client
-	sID.MaxAge = -1
-	http.SetCookie(w, sID)
+	del := newCookie("sID", sID.Value)
+	del.MaxAge = -1
+	http.SetCookie(w, del)
 
-	state, ok := states[sID.Value]
-	if !ok {
+	state, err := a.store.GetState(r.Context(), sID.Value)
+	if err == errNotFound {
 		http.Error(w, "OAuth state not found", http.StatusInternalServerError)
 		return nil, errors.New("OAuth state not found")
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, err
 	}
 
 	if st := r.FormValue("state"); st != state {
@@ -122,7 +168,9 @@ func checkStateAndGetToken(w http.ResponseWriter, r *http.Request) (*oauth2.Toke
 		return nil, errors.New("State mismatch\n")
 	}
 
-	tok, err := spotifyAuth.Token(state, r)
+	done := a.startSpotifyCall(r.Context(), "Token")
+	tok, err := a.spotify.Token(r.Context(), state, r)
+	done(err)
 
 	if err != nil {
 		http.Error(w, "Couldn't get token", http.StatusForbidden)
@@ -130,37 +178,33 @@ func checkStateAndGetToken(w http.ResponseWriter, r *http.Request) (*oauth2.Toke
 	}
 
 	// state no longer needed
-	delete(states, sID.Value)
+	if err := a.store.DeleteState(r.Context(), sID.Value); err != nil {
+		reqLogger(r).Warn("Deleting OAuth state", "err", err)
+	}
 
 	return tok, nil
 }
 
-func marshalAndEncryptToken(w http.ResponseWriter, tok *oauth2.Token) ([]byte, error) {
+func (a *App) marshalAndEncryptToken(w http.ResponseWriter, tok *oauth2.Token) ([]byte, error) {
 	bs, err := json.Marshal(*tok)
 	if err != nil {
 		return nil, err
 	}
 
-	enc := encrypt.Encrypt(key, string(bs))
+	enc := encrypt.Encrypt(a.cfg.EncryptionKey, string(bs))
 	return enc, nil
 }
 
 // Passing encrypted access token forces binding between local sessions and oAuth sessions.
-func createSession(w http.ResponseWriter, encToken []byte) (*session, error) {
+func (a *App) createSession(ctx context.Context, w http.ResponseWriter, encToken []byte, p *profile) (*session, error) {
 	// create session
 	sID, _ := uuid.NewV4()
-	c := &http.Cookie{
-		Name:  "session",
-		Value: sID.String(),
-	}
-	c.MaxAge = sessionLength
+	c := newCookie("session", sID.String())
 	http.SetCookie(w, c)
 
-	s := session{encToken,time.Now()}
+	s := session{Token: encToken, LastActivity: time.Now(), Profile: p}
 
-	// Save to Redis
-	//fmt.Printf("SessionID: %s\n", sID.String())
-	err := setSession(c.Value, s)
+	err := a.store.SetSession(ctx, c.Value, s)
 	if err != nil {
 		return nil, err
 	}
@@ -171,56 +215,105 @@ func createSession(w http.ResponseWriter, encToken []byte) (*session, error) {
 // getClient gets token from session and exchanges for client.
 // This function takes both the ReponseWriter and the Request,
 // so it will handle its own errors instead of leaving that to the handler
-func getClient(w http.ResponseWriter, req *http.Request) (*spotify.Client, error) {
+func (a *App) getClient(w http.ResponseWriter, req *http.Request) (*spotify.Client, error) {
+	client, _, _, err := a.sessionClient(w, req)
+	return client, err
+}
+
+// getUser is getClient plus the user's profile. The profile comes from
+// the session and is only fetched from Spotify when it is missing or
+// older than profileTTL. Like getClient, it handles its own errors.
+func (a *App) getUser(w http.ResponseWriter, req *http.Request) (*spotify.Client, *profile, error) {
+	client, sID, sesh, err := a.sessionClient(w, req)
+	if err != nil {
+		return nil, nil, err
+	}
+	if sesh.Profile != nil && time.Since(sesh.Profile.Fetched) < profileTTL {
+		return client, sesh.Profile, nil
+	}
+
+	p, err := a.fetchProfile(req.Context(), client)
+	if err != nil {
+		a.spotifyError(w, req, err)
+		return nil, nil, err
+	}
+
+	sesh.Profile = p
+	if err := a.store.SetSession(req.Context(), sID, *sesh); err != nil {
+		reqLogger(req).Warn("Saving profile", "err", err) // fetched again next time
+	}
+	return client, p, nil
+}
+
+// sessionClient looks up the request's session and builds
+// a client from its token.
+func (a *App) sessionClient(w http.ResponseWriter, req *http.Request) (*spotify.Client, string, *session, error) {
 	token := &oauth2.Token{}
 
 	// get session from cookie
-	sesh, err := getSession(w, req)
-	if err == redis.ErrNil {
+	c, err := req.Cookie("session")
+	if err != nil {
+		http.Redirect(w, req, "/authenticate", http.StatusTemporaryRedirect)
+		return nil, "", nil, err
+	}
+	sesh, err := a.store.GetSession(req.Context(), c.Value)
+	if err == errNotFound {
 		http.Redirect(w, req, "/authenticate", http.StatusTemporaryRedirect)
-		return nil, err // return error here so that the caller handler also returns
+		return nil, "", nil, err // return error here so that the caller handler also returns
 	} else if err != nil {
-		return nil, err
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, "", nil, err
 	}
 
 	// get token from session
-	jsonToken := encrypt.Decrypt(key, sesh.Token)
+	jsonToken := encrypt.Decrypt(a.cfg.EncryptionKey, sesh.Token)
 	err = json.Unmarshal([]byte(jsonToken), token)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Error unmarshalling token: %s", err.Error()), http.StatusInternalServerError)
-		return nil, err
+		return nil, "", nil, err
 	}
 
-	client := spotifyAuth.NewClient(token)
-
-	return &client, nil
+	return a.spotify.NewClient(req.Context(), token), c.Value, sesh, nil
 }
 
-func getSession(w http.ResponseWriter, req *http.Request) (*session, error) {
-	c, err := req.Cookie("session")
+// fetchProfile gets the user's profile from Spotify.
+func (a *App) fetchProfile(ctx context.Context, client *spotify.Client) (*profile, error) {
+	done := a.startSpotifyCall(ctx, "CurrentUser")
+	user, err := client.CurrentUser()
+	done(err)
 	if err != nil {
 		return nil, err
 	}
 
-	sesh, err := getSessionFromRedis(c.Value)
-	if err != nil {
-		return nil, err
+	p := &profile{
+		ID:          user.ID,
+		DisplayName: user.DisplayName,
+		Country:     user.Country,
+		Product:     user.Product,
+		Fetched:     time.Now(),
 	}
-
-	return sesh, nil
+	if p.DisplayName == "" {
+		p.DisplayName = user.ID
+	}
+	if len(user.Images) > 0 {
+		p.AvatarURL = user.Images[0].URL
+	}
+	return p, nil
 }
 
-func logout(w http.ResponseWriter, req *http.Request) {
+func (a *App) logout(w http.ResponseWriter, req *http.Request) {
 	c, err := req.Cookie("session")
 	if err != nil {
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
 
-	c.MaxAge = -1
-	http.SetCookie(w, c)
+	del := newCookie("session", c.Value)
+	del.MaxAge = -1
+	http.SetCookie(w, del)
 
-	if err := deleteSession(c.Value); err != nil {
+	if err := a.store.DeleteSession(req.Context(), c.Value); err != nil {
+		reqLogger(req).Error("Deleting session", "err", err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
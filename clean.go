@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"spotify-live-lyricist/pkg/config"
+	"spotify-live-lyricist/pkg/profanity"
+)
+
+const hiddenLyrics = "Lyrics hidden: this track is marked explicit."
+
+// cleanMode is what a session keeps off screen, for lyrics shown in
+// offices or at family events.
+type cleanMode struct {
+	Mask         bool // mask profanity in lyrics
+	HideExplicit bool // hide the lyrics of tracks Spotify marks explicit
+}
+
+// hides reports whether the lyrics of a track are hidden altogether.
+func (c cleanMode) hides(explicit bool) bool {
+	return c.HideExplicit && explicit
+}
+
+// loadProfanity builds the clean mode filter from the default word lists
+// and the files of PROFANITY_LISTS, which replace them.
+func loadProfanity(cfg *config.Config) (*profanity.Filter, error) {
+	lists := make(map[string][]string)
+	for lang, list := range profanity.Defaults {
+		lists[lang] = list
+	}
+	for lang, path := range cfg.ProfanityFiles() {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		list, err := profanity.ReadList(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %v", path, err)
+		}
+		lists[lang] = list
+	}
+	return profanity.New(lists), nil
+}
+
+// cleanMode returns the clean mode of the request's session, off if it
+// has none.
+func (a *App) cleanMode(r *http.Request) cleanMode {
+	c, err := r.Cookie("session")
+	if err != nil {
+		return cleanMode{}
+	}
+	sesh, err := a.store.GetSession(r.Context(), c.Value)
+	if err != nil {
+		return cleanMode{}
+	}
+	return sesh.Clean
+}
+
+// clean masks the profanity of lyrics in lang if the mode asks for it.
+func (a *App) clean(c cleanMode, lyrics, lang string) string {
+	if !c.Mask {
+		return lyrics
+	}
+	return a.cleaner.Mask(lyrics, lang)
+}
+
+// shownLyrics returns the lyrics of the track as the clean mode shows
+// them, or a message saying why there are none.
+func (a *App) shownLyrics(ctx context.Context, c cleanMode, track *Result) string {
+	lyrics, meta, ok := a.lyrics.lookupMeta(ctx, track.Artist, track.Title)
+	switch {
+	case !ok:
+		return notFoundLyrics
+	case c.hides(track.Explicit):
+		return hiddenLyrics
+	}
+	return a.clean(c, lyrics, meta.Language)
+}
+
+// cleanSettings turns the session's clean mode on or off, from the
+// checkboxes mask and hide_explicit.
+func (a *App) cleanSettings(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	_, sID, sesh, err := a.sessionClient(w, r)
+	if err != nil {
+		return
+	}
+
+	sesh.Clean = cleanMode{Mask: r.FormValue("mask") != "", HideExplicit: r.FormValue("hide_explicit") != ""}
+	if err := a.store.SetSession(r.Context(), sID, *sesh); err != nil {
+		reqLogger(r).Error("Saving clean mode", "err", err)
+		a.errors.record("store", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
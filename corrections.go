@@ -0,0 +1,234 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"spotify-live-lyricist/pkg/lyricDiff"
+	"spotify-live-lyricist/pkg/lyricText"
+	"strings"
+	"time"
+
+	"github.com/satori/go.uuid"
+)
+
+const (
+	revisionPending  = "pending"
+	revisionApproved = "approved"
+	revisionRejected = "rejected"
+
+	// limits of a submitted correction, which is diffed line by line
+	// against the current lyrics
+	correctionMaxBytes = 64 << 10
+	correctionMaxLines = 1000
+)
+
+// revision is a user-submitted correction of a track's lyrics.
+// Base holds the lyrics the user saw when editing, so that the
+// diff shown to moderators stays meaningful after later approvals.
+type revision struct {
+	ID            string
+	Artist, Title string
+	Version       int
+	UserID        string
+	Base, Text    string
+	Diff          []lyricDiff.Line
+	Status        string
+	Created       time.Time
+	Reviewer      string
+	Reviewed      time.Time
+}
+
+type correctionPage struct {
+	Artist, Title string
+	Text          string
+}
+
+// correctionForm shows the lyrics of a track in an editable form.
+func (a *App) correctionForm(w http.ResponseWriter, r *http.Request) {
+	artist, title := r.FormValue("artist"), r.FormValue("title")
+	if artist == "" || title == "" {
+		http.Error(w, "Missing artist or title", http.StatusBadRequest)
+		return
+	}
+
+	page := correctionPage{artist, title, a.lyrics.getCachedLyrics(r.Context(), artist, title)}
+	err := a.tpl.ExecuteTemplate(w, "correction.gohtml", page)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// submitCorrection stores the edited lyrics as a pending revision.
+func (a *App) submitCorrection(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	_, user, err := a.getUser(w, r)
+	if err != nil {
+		return
+	}
+
+	// room for the artist and title besides the text
+	r.Body = http.MaxBytesReader(w, r.Body, 2*correctionMaxBytes)
+	if err := r.ParseForm(); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, "Correction too large", http.StatusRequestEntityTooLarge)
+		} else {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+		return
+	}
+	artist, title := r.FormValue("artist"), r.FormValue("title")
+	if artist == "" || title == "" {
+		http.Error(w, "Missing artist or title", http.StatusBadRequest)
+		return
+	}
+	text := r.FormValue("text")
+	if len(text) > correctionMaxBytes || strings.Count(text, "\n") >= correctionMaxLines {
+		http.Error(w, fmt.Sprintf("Corrections are limited to %d lines and %d KB", correctionMaxLines, correctionMaxBytes>>10), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	base := a.lyrics.getCachedLyrics(r.Context(), artist, title)
+	text = lyricText.Sanitize(text)
+	diff := lyricDiff.Compute(base, text)
+	if text == "" || !lyricDiff.Changed(diff) {
+		http.Error(w, "No changes submitted", http.StatusBadRequest)
+		return
+	}
+
+	id, _ := uuid.NewV4()
+	rev := revision{
+		ID:      id.String(),
+		Artist:  artist,
+		Title:   title,
+		UserID:  user.ID,
+		Base:    base,
+		Text:    text,
+		Diff:    diff,
+		Status:  revisionPending,
+		Created: time.Now(),
+	}
+
+	if err := a.store.AddRevision(r.Context(), rev); err != nil {
+		reqLogger(r).Error("Saving revision", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// pendingRevision is a revision in the moderation queue, along with the
+// version of its track's lyrics approved so far, if any.
+type pendingRevision struct {
+	revision
+	Approved int
+}
+
+// Outdated reports whether a newer revision of the track was approved
+// since this one was submitted.
+func (p pendingRevision) Outdated() bool {
+	return p.Approved > p.Version
+}
+
+// moderationQueue lists the pending revisions with their diffs.
+func (a *App) moderationQueue(w http.ResponseWriter, r *http.Request) {
+	if _, ok := a.requireAdmin(w, r); !ok {
+		return
+	}
+
+	revs, err := a.store.PendingRevisions(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	queue := make([]pendingRevision, len(revs))
+	for i, rev := range revs {
+		queue[i].revision = rev
+		if queue[i].Approved, err = a.approvedVersion(r, rev); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	err = a.tpl.ExecuteTemplate(w, "moderation.gohtml", queue)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// moderateRevisionHandler approves or rejects a pending revision.
+func (a *App) moderateRevisionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	adminID, ok := a.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	rev, err := a.store.GetRevision(r.Context(), r.FormValue("id"))
+	if err != nil {
+		http.Error(w, "Revision not found", http.StatusNotFound)
+		return
+	}
+	if rev.Status != revisionPending {
+		http.Error(w, "Revision was already moderated", http.StatusConflict)
+		return
+	}
+
+	action := r.FormValue("action")
+	switch action {
+	case "approve", "replace":
+		rev.Status = revisionApproved
+	case "reject":
+		rev.Status = revisionRejected
+	default:
+		http.Error(w, "Unknown action", http.StatusBadRequest)
+		return
+	}
+	rev.Reviewer = adminID
+	rev.Reviewed = time.Now()
+
+	// an older revision must not silently undo a newer approved one
+	err = a.store.ModerateRevision(r.Context(), *rev, action == "replace")
+	switch {
+	case err == errModerated:
+		http.Error(w, "Revision was already moderated", http.StatusConflict)
+		return
+	case err == errOutdated:
+		approved, _ := a.approvedVersion(r, *rev)
+		http.Error(w, fmt.Sprintf("Version %d of these lyrics is already approved; replace it to approve version %d", approved, rev.Version), http.StatusConflict)
+		return
+	case err != nil:
+		reqLogger(r).Error("Moderating revision", "revision", rev.ID, "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	reqLogger(r).Info("Moderated revision", "revision", rev.ID, "status", rev.Status)
+	if rev.Status == revisionApproved {
+		a.lyrics.indexLyrics(rev.Artist, rev.Title, rev.Text)
+	}
+
+	http.Redirect(w, r, "/admin/revisions", http.StatusSeeOther)
+}
+
+// approvedVersion returns the version of the track's approved revision,
+// or 0 if there is none.
+func (a *App) approvedVersion(r *http.Request, rev revision) (int, error) {
+	approved, err := a.store.ApprovedRevision(r.Context(), rev.Artist, rev.Title)
+	if err == errNotFound {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	return approved.Version, nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"spotify-live-lyricist/pkg/fakeSpotify"
+)
+
+// post sends a form without following the redirect it answers with.
+func post(t *testing.T, c *http.Client, url string, form url.Values) *http.Response {
+	t.Helper()
+	noRedirect := *c
+	noRedirect.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+	resp, err := noRedirect.PostForm(url, form)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp
+}
+
+func TestSubmitCorrection(t *testing.T) {
+	app, fake, srv := newTestApp(t)
+	c := loggedIn(t, fake, srv)
+
+	tests := []struct {
+		name   string
+		text   string
+		status int
+	}{
+		{"too large", strings.Repeat("a", correctionMaxBytes+1), http.StatusRequestEntityTooLarge},
+		{"too many lines", strings.Repeat("la\n", correctionMaxLines), http.StatusRequestEntityTooLarge},
+		{"unchanged", "la la la\nsecond line", http.StatusBadRequest},
+		{"empty", "", http.StatusBadRequest},
+		{"changed", "la la la\nsecond verse", http.StatusSeeOther},
+	}
+	for _, tt := range tests {
+		form := url.Values{"artist": {"Artist"}, "title": {"Song"}, "text": {tt.text}}
+		if resp := post(t, c, srv.URL+"/corrections", form); resp.StatusCode != tt.status {
+			t.Errorf("%s: got %d, want %d", tt.name, resp.StatusCode, tt.status)
+		}
+	}
+
+	form := url.Values{"artist": {"Artist"}, "title": {"Song"}, "text": {strings.Repeat("a", 3*correctionMaxBytes)}}
+	if resp := post(t, c, srv.URL+"/corrections", form); resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("body over the limit: got %d", resp.StatusCode)
+	}
+
+	revs, err := app.store.PendingRevisions(context.Background())
+	if err != nil || len(revs) != 1 || revs[0].Text != "la la la\nsecond verse" || revs[0].UserID != fakeSpotify.DefaultUser {
+		t.Errorf("pending revisions: %+v, %v", revs, err)
+	}
+}
+
+func TestModerateRevision(t *testing.T) {
+	app, fake, srv := newTestApp(t)
+	c := loggedIn(t, fake, srv)
+	ctx := context.Background()
+	for _, id := range []string{"old", "new", "other"} {
+		if err := app.store.AddRevision(ctx, revision{ID: id, Artist: "Artist", Title: "Song", Text: id, Status: revisionPending}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	moderate := func(id, action string) int {
+		return post(t, c, srv.URL+"/admin/revisions/moderate", url.Values{"id": {id}, "action": {action}}).StatusCode
+	}
+	if status := moderate("new", "approve"); status != http.StatusForbidden {
+		t.Fatalf("not an admin: got %d", status)
+	}
+	app.admins[fakeSpotify.DefaultUser] = true
+
+	tests := []struct {
+		id, action string
+		status     int
+		approved   string
+	}{
+		{"new", "approve", http.StatusSeeOther, "new"},
+		{"old", "approve", http.StatusConflict, "new"},
+		{"old", "replace", http.StatusSeeOther, "old"},
+		{"old", "reject", http.StatusConflict, "old"},
+		{"other", "publish", http.StatusBadRequest, "old"},
+		{"other", "reject", http.StatusSeeOther, "old"},
+		{"missing", "approve", http.StatusNotFound, "old"},
+	}
+	for _, tt := range tests {
+		if status := moderate(tt.id, tt.action); status != tt.status {
+			t.Errorf("%s %s: got %d, want %d", tt.action, tt.id, status, tt.status)
+		}
+		if rev, err := app.store.ApprovedRevision(ctx, "Artist", "Song"); err != nil || rev.ID != tt.approved {
+			t.Errorf("%s %s: approved %+v, %v, want %s", tt.action, tt.id, rev, err, tt.approved)
+		}
+	}
+	if revs, _ := app.store.PendingRevisions(ctx); len(revs) != 0 {
+		t.Errorf("still pending: %+v", revs)
+	}
+}
+
+func TestModerateRevisionStore(t *testing.T) {
+	store := newMemoryStore()
+	ctx := context.Background()
+	for _, id := range []string{"v1", "v2"} {
+		store.AddRevision(ctx, revision{ID: id, Artist: "A", Title: "T", Status: revisionPending})
+	}
+	v1, _ := store.GetRevision(ctx, "v1")
+	v2, _ := store.GetRevision(ctx, "v2")
+	v1.Status, v2.Status = revisionApproved, revisionApproved
+
+	if err := store.ModerateRevision(ctx, *v2, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.ModerateRevision(ctx, *v2, false); err != errModerated {
+		t.Errorf("twice: got %v, want errModerated", err)
+	}
+	if err := store.ModerateRevision(ctx, *v1, false); err != errOutdated {
+		t.Errorf("older: got %v, want errOutdated", err)
+	}
+	if err := store.ModerateRevision(ctx, *v1, true); err != nil {
+		t.Errorf("replace: got %v", err)
+	}
+}
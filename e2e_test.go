@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"spotify-live-lyricist/pkg/fakeSpotify"
+)
+
+// TestEndToEnd logs in through the fake Spotify one redirect at a time,
+// then reads the player, the lyrics API and the now-playing stream.
+func TestEndToEnd(t *testing.T) {
+	_, fake, srv := newTestApp(t)
+	fake.Play(fakeSpotify.DefaultUser, fakeSpotify.Track("t1", "Artist", "Song", 3*time.Minute))
+
+	jar, _ := cookiejar.New(nil)
+	c := &http.Client{
+		Jar:           jar,
+		Transport:     fake.Transport(),
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	redirect := func(url string, status int) string {
+		t.Helper()
+		resp, body := get(t, c, url)
+		if resp.StatusCode != status {
+			t.Fatalf("GET %s: got %d, want %d\n%s", url, resp.StatusCode, status, body)
+		}
+		return resp.Header.Get("Location")
+	}
+
+	if loc := redirect(srv.URL+"/", http.StatusSeeOther); loc != "/authenticate" {
+		t.Fatalf("logged out player redirects to %q", loc)
+	}
+	authorize := redirect(srv.URL+"/authenticate", http.StatusTemporaryRedirect)
+	if !strings.HasPrefix(authorize, "https://accounts.spotify.com/authorize?") {
+		t.Fatalf("login redirects to %q", authorize)
+	}
+	callback := redirect(authorize, http.StatusFound)
+	if !strings.HasPrefix(callback, srv.URL+"/callback?") {
+		t.Fatalf("Spotify redirects to %q", callback)
+	}
+	if loc := redirect(callback, http.StatusTemporaryRedirect); loc != "/" {
+		t.Fatalf("callback redirects to %q", loc)
+	}
+
+	resp, body := get(t, c, srv.URL+"/")
+	if resp.StatusCode != http.StatusOK || !strings.Contains(body, "la la la") || !strings.Contains(body, "Fake User") {
+		t.Fatalf("player: got %d\n%s", resp.StatusCode, body)
+	}
+
+	resp, body = get(t, c, srv.URL+"/api/v1/lyrics")
+	var lyrics struct {
+		Title  string
+		Found  bool
+		Lyrics string
+	}
+	if err := json.Unmarshal([]byte(body), &lyrics); err != nil || !lyrics.Found || lyrics.Lyrics != "la la la\nsecond line" {
+		t.Fatalf("lyrics API: got %d %v\n%s", resp.StatusCode, err, body)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/now-playing", nil)
+	resp, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("now playing: got Content-Type %q", ct)
+	}
+	line, err := bufio.NewReader(resp.Body).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	var np nowPlaying
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &np); err != nil {
+		t.Fatalf("now playing: %v in %q", err, line)
+	}
+	if !np.Playing || np.Artist != "Artist" || np.Title != "Song" || np.DurationMs != 180000 {
+		t.Errorf("now playing: got %+v", np)
+	}
+}
+
+func TestLoginRejected(t *testing.T) {
+	_, fake, srv := newTestApp(t)
+
+	tests := []struct {
+		name   string
+		deny   bool
+		state  string
+		status int
+	}{
+		{name: "denied", deny: true, status: http.StatusForbidden},
+		{name: "forged state", state: "forged", status: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake.DenyAuth(tt.deny)
+			jar, _ := cookiejar.New(nil)
+			c := &http.Client{
+				Jar:           jar,
+				Transport:     fake.Transport(),
+				CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+			}
+			resp, _ := get(t, c, srv.URL+"/authenticate")
+			resp, _ = get(t, c, resp.Header.Get("Location"))
+			callback, err := url.Parse(resp.Header.Get("Location"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.state != "" {
+				q := callback.Query()
+				q.Set("state", tt.state)
+				callback.RawQuery = q.Encode()
+			}
+
+			resp, body := get(t, c, callback.String())
+			if resp.StatusCode != tt.status {
+				t.Errorf("callback: got %d, want %d\n%s", resp.StatusCode, tt.status, body)
+			}
+			u, _ := url.Parse(srv.URL)
+			for _, cookie := range jar.Cookies(u) {
+				if cookie.Name == "session" {
+					t.Errorf("got a session")
+				}
+			}
+		})
+	}
+}
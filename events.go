@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// nowPlayingEvents streams the user's player state as server-sent
+// events, from the user's shared poller.
+func (a *App) nowPlayingEvents(w http.ResponseWriter, r *http.Request) {
+	client, user, err := a.getUser(w, r)
+	if err != nil {
+		return
+	}
+
+	// the stream outlives the server's write timeout
+	rc := http.NewResponseController(w)
+	rc.SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // tell nginx not to buffer the stream
+	if err := rc.Flush(); err != nil {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	updates, unsubscribe := a.players.subscribe(user.ID, client)
+	defer unsubscribe()
+	clean := a.cleanMode(r)
+
+	for {
+		select {
+		case np := <-updates:
+			np.LyricsHidden = clean.hides(np.Explicit)
+			data, err := json.Marshal(np)
+			if err != nil {
+				reqLogger(r).Error("Encoding now playing", "err", err)
+				return
+			}
+			fmt.Fprintf(w, "data: %s\n\n", data)
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		case <-a.draining:
+			return
+		}
+	}
+}
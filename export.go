@@ -0,0 +1,463 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/satori/go.uuid"
+	"github.com/zmb3/spotify"
+	"golang.org/x/oauth2"
+	"spotify-live-lyricist/pkg/config"
+	"spotify-live-lyricist/pkg/logging"
+	"spotify-live-lyricist/pkg/lyricExport"
+	"spotify-live-lyricist/pkg/lyricLang"
+	"spotify-live-lyricist/pkg/lyricSearch"
+)
+
+const (
+	exportMaxTracks = 2000
+	exportWorkers   = 4 // lyric lookups running at once for one export
+	exportJobTTL    = time.Hour
+	exportMaxBytes  = 256 << 20 // finished booklets kept, in total; the oldest go first
+	likedSongs      = "liked"
+)
+
+// exportJob builds a booklet in the background for one user.
+type exportJob struct {
+	ID, UserID string
+	format     lyricExport.Format
+	created    time.Time
+
+	mutex       sync.Mutex
+	done, total int
+	book        *lyricExport.Book
+	data        []byte
+	err         error
+}
+
+type exportStatus struct {
+	State    string   `json:"state"` // running, done or failed
+	Done     int      `json:"done"`
+	Total    int      `json:"total"`
+	Missing  []string `json:"missing,omitempty"`
+	Error    string   `json:"error,omitempty"`
+	Download string   `json:"download,omitempty"`
+}
+
+type exportJobs struct {
+	mutex sync.Mutex
+	byID  map[string]*exportJob
+}
+
+func newExportJobs() *exportJobs {
+	return &exportJobs{byID: make(map[string]*exportJob)}
+}
+
+// add keeps a job until exportJobTTL has passed. Each user runs one job
+// at a time, so it reports false if the user's last one is still running.
+func (e *exportJobs) add(job *exportJob) bool {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	for id, j := range e.byID {
+		if time.Since(j.created) > exportJobTTL {
+			delete(e.byID, id)
+		}
+	}
+	for _, j := range e.byID {
+		if j.UserID == job.UserID && j.running() {
+			return false
+		}
+	}
+	e.byID[job.ID] = job
+	return true
+}
+
+// trim drops the oldest finished jobs other than keep while their
+// booklets hold more than exportMaxBytes.
+func (e *exportJobs) trim(keep *exportJob) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	var finished []*exportJob
+	total := 0
+	for _, j := range e.byID {
+		if size := j.size(); size > 0 {
+			finished = append(finished, j)
+			total += size
+		}
+	}
+	sort.Slice(finished, func(i, k int) bool { return finished[i].created.Before(finished[k].created) })
+	for _, j := range finished {
+		if total <= exportMaxBytes {
+			break
+		}
+		if j != keep {
+			delete(e.byID, j.ID)
+			total -= j.size()
+		}
+	}
+}
+
+// get returns the job only to the user who started it.
+func (e *exportJobs) get(id, userID string) (*exportJob, bool) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	job, ok := e.byID[id]
+	if !ok || job.UserID != userID {
+		return nil, false
+	}
+	return job, true
+}
+
+func (j *exportJob) running() bool {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	return j.data == nil && j.err == nil
+}
+
+func (j *exportJob) size() int {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	return len(j.data)
+}
+
+func (j *exportJob) progress(done, total int) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	j.done, j.total = done, total
+}
+
+func (j *exportJob) status() exportStatus {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	s := exportStatus{State: "running", Done: j.done, Total: j.total}
+	switch {
+	case j.err != nil:
+		s.State = "failed"
+		s.Error = j.err.Error()
+	case j.data != nil:
+		s.State = "done"
+		s.Download = "/export/download?id=" + j.ID
+		for _, t := range j.book.Missing() {
+			s.Missing = append(s.Missing, t.Artist+" - "+t.Title)
+		}
+	}
+	return s
+}
+
+// exportBook resolves the lyrics of every track of a playlist, or of the
+// liked songs, through the cache and the providers, without caching
+// what the providers return.
+func (a *App) exportBook(ctx context.Context, client *spotify.Client, source string, progress func(done, total int)) (*lyricExport.Book, error) {
+	book := &lyricExport.Book{Created: time.Now()}
+
+	var tracks []trackRef
+	var total int
+	var err error
+	if source == likedSongs {
+		book.Title = "Liked Songs"
+		tracks, total, err = a.savedTracks(ctx, client, exportMaxTracks)
+	} else {
+		id := playlistID(source)
+		done := a.startSpotifyCall(ctx, "GetPlaylist")
+		var pl *spotify.FullPlaylist
+		pl, err = client.GetPlaylistOpt(id, "name")
+		done(err)
+		if err != nil {
+			return nil, err
+		}
+		book.Title = pl.Name
+		tracks, total, err = a.playlistTracks(ctx, client, id, exportMaxTracks)
+	}
+	if err != nil {
+		return nil, err
+	}
+	book.Truncated = total > len(tracks)
+
+	book.Tracks = make([]lyricExport.Track, len(tracks))
+	progress(0, len(tracks))
+
+	var mutex sync.Mutex
+	done := 0
+	parallel(len(tracks), exportWorkers, func(i int) {
+		t := tracks[i]
+		a.lyrics.index.SetURI(t.Artist, t.Title, "spotify:track:"+string(t.ID))
+		lyrics, meta, ok := a.lyrics.readThrough(ctx, t.Artist, t.Title)
+		book.Tracks[i] = lyricExport.Track{Artist: t.Artist, Title: t.Title, Album: t.Album, Lyrics: lyrics, Found: ok}
+		if meta.Language != lyricLang.Unknown {
+			book.Tracks[i].Language = meta.Language
+		}
+
+		mutex.Lock()
+		done++
+		progress(done, len(tracks))
+		mutex.Unlock()
+	})
+
+	return book, nil
+}
+
+// parallel calls f for 0 to n-1 on up to workers goroutines, and returns
+// once every call has.
+func parallel(n, workers int, f func(i int)) {
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				f(i)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+}
+
+// savedTracks lists up to max of the user's liked songs, and
+// returns how many there are.
+func (a *App) savedTracks(ctx context.Context, client *spotify.Client, max int) ([]trackRef, int, error) {
+	var tracks []trackRef
+	total := 0
+	for offset := 0; offset < max; offset += pageSize {
+		limit, off := pageSize, offset
+		done := a.startSpotifyCall(ctx, "CurrentUsersTracks")
+		page, err := client.CurrentUsersTracksOpt(&spotify.Options{Limit: &limit, Offset: &off})
+		done(err)
+		if err != nil {
+			return nil, 0, err
+		}
+		for _, st := range page.Tracks {
+			t := trackOf(st.SimpleTrack)
+			t.Album = st.Album.Name
+			tracks = append(tracks, t)
+		}
+		total = page.Total
+		if offset+len(page.Tracks) >= page.Total {
+			break
+		}
+	}
+	return tracks, total, nil
+}
+
+// playlistTracks lists up to max tracks of a playlist, and returns
+// how many it has.
+func (a *App) playlistTracks(ctx context.Context, client *spotify.Client, id spotify.ID, max int) ([]trackRef, int, error) {
+	var tracks []trackRef
+	total := 0
+	for offset := 0; offset < max; offset += pageSize {
+		limit, off := pageSize, offset
+		done := a.startSpotifyCall(ctx, "GetPlaylistTracks")
+		page, err := client.GetPlaylistTracksOpt(id, &spotify.Options{Limit: &limit, Offset: &off}, "")
+		done(err)
+		if err != nil {
+			return nil, 0, err
+		}
+		for _, pt := range page.Tracks {
+			t := trackOf(pt.Track.SimpleTrack)
+			t.Album = pt.Track.Album.Name
+			tracks = append(tracks, t)
+		}
+		total = page.Total
+		if offset+len(page.Tracks) >= page.Total {
+			break
+		}
+	}
+	return tracks, total, nil
+}
+
+// playlistID accepts a playlist ID, URI or open.spotify.com link.
+func playlistID(source string) spotify.ID {
+	source = strings.TrimSpace(source)
+	if u, err := url.Parse(source); err == nil && u.Host != "" {
+		source = strings.TrimSuffix(u.Path, "/")
+		source = source[strings.LastIndex(source, "/")+1:]
+	}
+	return spotify.ID(source[strings.LastIndex(source, ":")+1:])
+}
+
+// exportPage shows the export form, or the progress of an export.
+func (a *App) exportPage(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		a.startExport(w, r)
+		return
+	}
+
+	err := a.tpl.ExecuteTemplate(w, "export.gohtml", struct{ Job string }{r.FormValue("job")})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// startExport builds the booklet in the background and sends the
+// user to the page following its progress.
+func (a *App) startExport(w http.ResponseWriter, r *http.Request) {
+	client, user, err := a.getUser(w, r)
+	if err != nil {
+		return
+	}
+
+	source := r.FormValue("source")
+	format, ok := lyricExport.Formats[r.FormValue("format")]
+	if source == "" || !ok {
+		http.Error(w, "Missing playlist or unknown format", http.StatusBadRequest)
+		return
+	}
+
+	id, _ := uuid.NewV4()
+	job := &exportJob{ID: id.String(), UserID: user.ID, format: format, created: time.Now()}
+	if !a.exports.add(job) {
+		http.Error(w, "An export is already running, try again once it is done", http.StatusTooManyRequests)
+		return
+	}
+
+	ctx := context.WithoutCancel(r.Context())
+	go func() {
+		book, err := a.exportBook(ctx, client, source, job.progress)
+		var buf bytes.Buffer
+		if err == nil {
+			err = format.Write(&buf, book)
+		}
+
+		job.mutex.Lock()
+		if err != nil {
+			logging.FromContext(ctx).Error("Exporting lyrics", "source", source, "err", err)
+			job.err = err
+		} else {
+			job.book, job.data = book, buf.Bytes()
+		}
+		job.mutex.Unlock()
+		a.exports.trim(job)
+	}()
+
+	http.Redirect(w, r, "/export?job="+job.ID, http.StatusSeeOther)
+}
+
+// exportStatusHandler reports the progress of an export as JSON.
+func (a *App) exportStatusHandler(w http.ResponseWriter, r *http.Request) {
+	_, user, err := a.getUser(w, r)
+	if err != nil {
+		return
+	}
+	job, ok := a.exports.get(r.FormValue("id"), user.ID)
+	if !ok {
+		http.Error(w, "Export not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job.status())
+}
+
+// exportDownload sends the finished booklet.
+func (a *App) exportDownload(w http.ResponseWriter, r *http.Request) {
+	_, user, err := a.getUser(w, r)
+	if err != nil {
+		return
+	}
+	job, ok := a.exports.get(r.FormValue("id"), user.ID)
+	if !ok {
+		http.Error(w, "Export not found", http.StatusNotFound)
+		return
+	}
+
+	job.mutex.Lock()
+	data, book := job.data, job.book
+	job.mutex.Unlock()
+	if data == nil {
+		http.Error(w, "Export not finished", http.StatusConflict)
+		return
+	}
+
+	w.Header().Set("Content-Type", job.format.ContentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", book.Filename()+job.format.Extension))
+	w.Write(data)
+}
+
+// runExport is the export command: it writes the booklet of a playlist
+// or the liked songs to a file, reporting progress on stderr. It needs
+// a Spotify access token with the playlist-read-private and
+// user-library-read scopes.
+func runExport(args []string) int {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	configPath := fs.String("config", os.Getenv("CONFIG_FILE"), "optional YAML or TOML config file")
+	source := fs.String("playlist", "", `playlist ID, URI or link, or "liked" for the liked songs`)
+	formatName := fs.String("format", "md", "md, html, epub or lrc (a ZIP of LRC files)")
+	out := fs.String("o", "", "output file (default: the playlist's name)")
+	token := fs.String("token", os.Getenv("SPOTIFY_TOKEN"), "Spotify access token")
+	fs.Parse(args)
+
+	format, ok := lyricExport.Formats[*formatName]
+	if *source == "" || *token == "" || !ok {
+		fs.Usage()
+		return 2
+	}
+
+	// the command doesn't serve, so settings only the server needs may be missing
+	cfg, err := config.Load(*configPath)
+	var invalid config.ValidationError
+	if err != nil && !errors.As(err, &invalid) {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	logger := logging.New(cfg.Production, os.Stderr)
+	m := newMetrics()
+	store := newStore(cfg, m)
+	defer store.Close()
+	errs := newErrorLog()
+	// the server may be using the index file, so keep this one in memory
+	index, _ := lyricSearch.Open("")
+	app := NewApp(cfg, Deps{
+		Logger:  logger,
+		Store:   store,
+		Lyrics:  newLyricsService(defaultProviders(cfg.GeniusToken), cfg.ProviderOrder(), store, index, m, errs),
+		Spotify: newSpotifyFactory(cfg.RedirectURI(), cfg.SpotifyID, cfg.SpotifySecret, nil, m),
+		Metrics: m,
+		Errors:  errs,
+	})
+
+	ctx := logging.WithContext(context.Background(), logger)
+	client := app.spotify.NewClient(ctx, &oauth2.Token{AccessToken: *token})
+	book, err := app.exportBook(ctx, client, *source, func(done, total int) {
+		fmt.Fprintf(os.Stderr, "\r%d/%d tracks", done, total)
+	})
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if *out == "" {
+		*out = book.Filename() + format.Extension
+	}
+	f, err := os.Create(*out)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer f.Close()
+	if err := format.Write(f, book); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	fmt.Fprintf(os.Stderr, "Wrote %s\n%s", *out, book.Summary())
+	return 0
+}
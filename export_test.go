@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+
+	"spotify-live-lyricist/pkg/fakeSpotify"
+)
+
+func TestExportJobs(t *testing.T) {
+	jobs := newExportJobs()
+	first := &exportJob{ID: "1", UserID: "u", created: time.Now()}
+	if !jobs.add(first) {
+		t.Fatal("first job refused")
+	}
+	if jobs.add(&exportJob{ID: "2", UserID: "u", created: time.Now()}) {
+		t.Error("second running job of a user accepted")
+	}
+	if !jobs.add(&exportJob{ID: "3", UserID: "other", created: time.Now()}) {
+		t.Error("job of another user refused")
+	}
+
+	first.data = make([]byte, exportMaxBytes/2+1)
+	second := &exportJob{ID: "4", UserID: "u", created: time.Now().Add(time.Second)}
+	if !jobs.add(second) {
+		t.Fatal("job after a finished one refused")
+	}
+	second.data = make([]byte, exportMaxBytes/2+1)
+	jobs.trim(second)
+	for id, want := range map[string]bool{"1": false, "3": true, "4": true} {
+		if _, ok := jobs.byID[id]; ok != want {
+			t.Errorf("job %s kept: %v, want %v", id, ok, want)
+		}
+	}
+}
+
+func TestExportBookLeavesCache(t *testing.T) {
+	app, fake, _ := newTestApp(t)
+	ctx := context.Background()
+	fake.AddPlaylist("pl",
+		fakeSpotify.Track("t1", "Artist", "Song", 3*time.Minute),
+		fakeSpotify.Track("t2", "Other", "Tune", 3*time.Minute),
+		fakeSpotify.Track("t3", "Nobody", "Missing", 3*time.Minute))
+	app.lyrics.put("Artist", "Song", "la la la\nsecond line", "fake")
+
+	client := app.spotify.NewClient(ctx, &oauth2.Token{AccessToken: fakeToken(t, fake, fakeSpotify.DefaultUser)})
+	book, err := app.exportBook(ctx, client, "spotify:playlist:pl", func(int, int) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []bool{true, true, false} {
+		if book.Tracks[i].Found != want {
+			t.Errorf("track %d: found %v, want %v", i+1, book.Tracks[i].Found, want)
+		}
+	}
+	if book.Tracks[1].Lyrics != "lyrics of Tune\nline & two" {
+		t.Errorf("got lyrics %q", book.Tracks[1].Lyrics)
+	}
+	if app.lyrics.cached("Other", "Tune") || !app.lyrics.cached("Artist", "Song") {
+		t.Errorf("export changed the cache: %v", app.lyrics.cache.lSet.Entries())
+	}
+}
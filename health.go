@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+func (a *App) startDraining() {
+	a.drainingOnce.Do(func() { close(a.draining) })
+}
+
+func (a *App) isDraining() bool {
+	select {
+	case <-a.draining:
+		return true
+	default:
+		return false
+	}
+}
+
+// healthz tells the orchestrator that the process is alive.
+func (a *App) healthz(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("ok\n"))
+}
+
+// readyz reports whether the app can serve users: the store answers,
+// the templates are parsed, Spotify is configured and the server
+// is not shutting down.
+func (a *App) readyz(w http.ResponseWriter, r *http.Request) {
+	checks := map[string]string{
+		"store":     "ok",
+		"templates": "ok",
+		"spotify":   "ok",
+		"server":    "ok",
+	}
+	ready := true
+	fail := func(check, reason string) {
+		checks[check] = reason
+		ready = false
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+	defer cancel()
+	if err := a.store.Ping(ctx); err != nil {
+		fail("store", err.Error())
+	}
+	if a.tpl == nil || a.tpl.Lookup("index.gohtml") == nil {
+		fail("templates", "index.gohtml not loaded")
+	}
+	if a.cfg.SpotifyID == "" || a.cfg.SpotifySecret == "" || a.cfg.EncryptionKey == "" {
+		fail("spotify", "SPOTIFY_ID, SPOTIFY_SECRET and ENCRYPTION_KEY must be set")
+	}
+	if a.isDraining() {
+		fail("server", "shutting down")
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if !ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(checks)
+}
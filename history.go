@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/zmb3/spotify"
+	"spotify-live-lyricist/pkg/lyricSync"
+)
+
+const (
+	historyLimit        = 5000 // plays kept per user
+	historyMergeWindow  = 200  // latest plays new ones are checked against
+	historyPageSize     = 100  // plays shown on /history
+	historyLyricsTracks = 20   // tracks whose lyrics /history shows
+)
+
+// play is one track of a user's listening history.
+type play struct {
+	TrackID    string    `json:"track_id"`
+	Artist     string    `json:"artist"`
+	Title      string    `json:"title"`
+	Album      string    `json:"album,omitempty"`
+	DurationMs int       `json:"duration_ms"`
+	Explicit   bool      `json:"explicit,omitempty"`
+	PlayedAt   time.Time `json:"played_at"` // when the track started
+}
+
+// historyEntry is a track of the history page with every time it was
+// played, newest first.
+type historyEntry struct {
+	play
+	Times  []time.Time
+	Looked bool // whether the lyrics were looked for in the cache
+	Found  bool
+	Lyrics string
+	Source string
+}
+
+// recordPlaying adds the track a poller saw starting to the user's history.
+func (a *App) recordPlaying(userID string, np nowPlaying) {
+	p := play{
+		TrackID:    np.TrackID,
+		Artist:     np.Artist,
+		Title:      np.Title,
+		Album:      np.Album,
+		DurationMs: np.DurationMs,
+		Explicit:   np.Explicit,
+		PlayedAt:   np.Fetched.Add(-time.Duration(np.ProgressMs) * time.Millisecond),
+	}
+	if err := a.recordPlays(context.Background(), userID, []play{p}); err != nil {
+		a.logger.Error("Recording play", "user", userID, "err", err)
+		a.errors.record("store", err)
+	}
+}
+
+// recordPlays adds to the user's history the plays it doesn't have yet.
+// The pollers and the recently played list see the same plays, at
+// slightly different times: two plays of a track starting less than
+// the track's length apart are taken for one.
+func (a *App) recordPlays(ctx context.Context, userID string, plays []play) error {
+	a.historyMutex.Lock()
+	defer a.historyMutex.Unlock()
+
+	known, err := a.store.History(ctx, userID, historyMergeWindow)
+	if err != nil {
+		return err
+	}
+	var added []play
+	for _, p := range plays {
+		if !samePlayIn(known, p) && !samePlayIn(added, p) {
+			added = append(added, p)
+		}
+	}
+	return a.store.AddPlays(ctx, userID, added)
+}
+
+func samePlayIn(plays []play, p play) bool {
+	window := time.Duration(p.DurationMs) * time.Millisecond
+	if window < time.Minute {
+		window = time.Minute
+	}
+	for _, k := range plays {
+		d := k.PlayedAt.Sub(p.PlayedAt)
+		if k.TrackID == p.TrackID && d < window && d > -window {
+			return true
+		}
+	}
+	return false
+}
+
+// recentlyPlayed lists the user's last plays according to Spotify,
+// which also knows of the plays while the app was closed.
+func (a *App) recentlyPlayed(ctx context.Context, client *spotify.Client) ([]play, error) {
+	done := a.startSpotifyCall(ctx, "PlayerRecentlyPlayed")
+	items, err := client.PlayerRecentlyPlayedOpt(&spotify.RecentlyPlayedOptions{Limit: 50})
+	done(err)
+	if err != nil {
+		return nil, err
+	}
+
+	plays := make([]play, 0, len(items))
+	for _, item := range items {
+		t := item.Track
+		p := play{
+			TrackID:    string(t.ID),
+			Title:      t.Name,
+			DurationMs: t.Duration,
+			Explicit:   t.Explicit,
+			// Spotify stamps a play when it ends
+			PlayedAt: item.PlayedAt.Add(-time.Duration(t.Duration) * time.Millisecond),
+		}
+		if len(t.Artists) > 0 {
+			p.Artist = t.Artists[0].Name
+		}
+		plays = append(plays, p)
+	}
+	return plays, nil
+}
+
+// historyPage lists the user's latest tracks with their lyrics, or
+// exports the whole history with format=csv or format=json. Either way
+// it first merges in Spotify's recently played list.
+func (a *App) historyPage(w http.ResponseWriter, r *http.Request) {
+	client, user, err := a.getUser(w, r)
+	if err != nil {
+		return
+	}
+	ctx := r.Context()
+
+	if recent, err := a.recentlyPlayed(ctx, client); err != nil {
+		// the stored history is still worth showing
+		reqLogger(r).Warn("Getting recently played tracks", "err", err)
+		a.errors.record("spotify", err)
+	} else if err := a.recordPlays(ctx, user.ID, recent); err != nil {
+		reqLogger(r).Error("Recording plays", "err", err)
+		a.errors.record("store", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	format := r.FormValue("format")
+	limit := historyPageSize
+	if format != "" {
+		limit = historyLimit
+	}
+	plays, err := a.store.History(ctx, user.ID, limit)
+	if err != nil {
+		reqLogger(r).Error("Getting history", "err", err)
+		a.errors.record("store", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	switch format {
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="history.csv"`)
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"played_at", "track_id", "artist", "title", "album", "duration_ms"})
+		for _, p := range plays {
+			cw.Write([]string{p.PlayedAt.UTC().Format(time.RFC3339), p.TrackID, p.Artist, p.Title, p.Album, strconv.Itoa(p.DurationMs)})
+		}
+		cw.Flush()
+	case "json":
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", `attachment; filename="history.json"`)
+		if plays == nil {
+			plays = []play{}
+		}
+		json.NewEncoder(w).Encode(plays)
+	case "":
+		entries := a.historyEntries(ctx, a.cleanMode(r), plays)
+		if err := a.tpl.ExecuteTemplate(w, "history.gohtml", entries); err != nil {
+			reqLogger(r).Error("Rendering history", "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	default:
+		http.Error(w, "Unknown format", http.StatusBadRequest)
+	}
+}
+
+// historyEntries groups plays by track, in the order each track was
+// last played, and shows the lyrics of the first tracks as the clean
+// mode does. It only reads corrections and the cache: the lyrics of the
+// other tracks are left to the prefetcher, for the next visit.
+func (a *App) historyEntries(ctx context.Context, clean cleanMode, plays []play) []historyEntry {
+	var entries []historyEntry
+	byTrack := make(map[string]int)
+	for _, p := range plays {
+		i, ok := byTrack[p.TrackID]
+		if !ok {
+			i = len(entries)
+			byTrack[p.TrackID] = i
+			entries = append(entries, historyEntry{play: p})
+		}
+		entries[i].Times = append(entries[i].Times, p.PlayedAt)
+	}
+
+	n := len(entries)
+	if n > historyLyricsTracks {
+		n = historyLyricsTracks
+	}
+	var misses []trackRef
+	for i := range entries[:n] {
+		e := &entries[i]
+		lyrics, meta, ok := a.lyrics.lookupStored(ctx, e.Artist, e.Title)
+		if !ok {
+			misses = append(misses, trackRef{ID: spotify.ID(e.TrackID), Artist: e.Artist, Title: e.Title, Album: e.Album})
+		} else if clean.hides(e.Explicit) {
+			lyrics = hiddenLyrics
+		}
+		e.Looked, e.Found, e.Lyrics, e.Source = true, ok, lyricSync.Plain(a.clean(clean, lyrics, meta.Language)), meta.Source
+	}
+	a.prefetch.later(ctx, misses)
+	return entries
+}
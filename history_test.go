@@ -0,0 +1,35 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"spotify-live-lyricist/pkg/fakeSpotify"
+)
+
+func TestHistoryLyrics(t *testing.T) {
+	app, fake, srv := newTestApp(t)
+	c := loggedIn(t, fake, srv)
+
+	// the player caches the lyrics of the first track only
+	fake.Play(fakeSpotify.DefaultUser, fakeSpotify.Track("t1", "Artist", "Song", 3*time.Minute))
+	get(t, c, srv.URL+"/")
+	fake.Play(fakeSpotify.DefaultUser, fakeSpotify.Track("t2", "Other", "Tune", 3*time.Minute))
+	app.recordPlaying(fakeSpotify.DefaultUser, nowPlaying{Playing: true, TrackID: "t2", Artist: "Other", Title: "Tune", DurationMs: 180000, Fetched: time.Now()})
+
+	_, body := get(t, c, srv.URL+"/history")
+	for _, want := range []string{"Artist - Song", "la la la", "Other - Tune", "Lyrics unknown"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("history lacks %q\n%s", want, body)
+		}
+	}
+	if strings.Contains(body, "lyrics of Tune") {
+		t.Errorf("history fetched lyrics")
+	}
+
+	waitFor(t, func() bool { return app.lyrics.cached("Other", "Tune") })
+	if _, body = get(t, c, srv.URL+"/history"); !strings.Contains(body, "lyrics of Tune") {
+		t.Errorf("prefetched lyrics not shown\n%s", body)
+	}
+}
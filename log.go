@@ -0,0 +1,37 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"regexp"
+	"spotify-live-lyricist/pkg/logging"
+
+	"github.com/satori/go.uuid"
+)
+
+const requestIDHeader = "X-Request-ID"
+
+// nginx's $request_id is 32 hex characters, our own IDs are UUIDs.
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9\-]{1,64}$`)
+
+// requestIDMiddleware tags every request with an ID, reusing the one
+// forwarded by nginx, and puts a logger carrying it in the request context.
+func (a *App) requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		id := req.Header.Get(requestIDHeader)
+		if !validRequestID.MatchString(id) {
+			u, _ := uuid.NewV4()
+			id = u.String()
+		}
+		w.Header().Set(requestIDHeader, id)
+
+		l := a.logger.With("request_id", id)
+		l.Debug("Request", "method", req.Method, "path", req.URL.Path)
+		next.ServeHTTP(w, req.WithContext(logging.WithContext(req.Context(), l)))
+	})
+}
+
+// reqLogger returns the logger of the request, tagged with its ID.
+func reqLogger(r *http.Request) *slog.Logger {
+	return logging.FromContext(r.Context())
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"spotify-live-lyricist/pkg/logging"
+	"spotify-live-lyricist/pkg/lyricLang"
+	"spotify-live-lyricist/pkg/lyricSearch"
+	"spotify-live-lyricist/pkg/lyricSync"
+	"spotify-live-lyricist/pkg/lyricTreeSet"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const cacheLimit = 300
+
+const notFoundLyrics = "Lyrics not found :("
+
+// sourceCorrection is the source of lyrics from an approved correction;
+// other lyrics come from the provider named as their source.
+const sourceCorrection = "correction"
+
+type cache struct {
+	lSet			*lyricTreeSet.LyricsSet
+	meta			map[lyricTreeSet.Entry]lyricMeta // source and language of each cached lyric
+	guesses			map[lyricTreeSet.Entry]string // language guessed from Spotify's data
+	mutex			sync.Mutex
+	hits, misses	int
+}
+
+func newCache() *cache {
+	return &cache{
+		lSet:    lyricTreeSet.New(cacheLimit),
+		meta:    make(map[lyricTreeSet.Entry]lyricMeta),
+		guesses: make(map[lyricTreeSet.Entry]string),
+	}
+}
+
+// lyricMeta tells where lyrics came from and the language they are in.
+type lyricMeta struct {
+	Source		string
+	Language	string
+}
+
+// lyricsService resolves lyrics through approved corrections,
+// the in-memory cache and the lyric providers, in that order. Every
+// lyric it returns is added to the search index.
+type lyricsService struct {
+	providers []lyricProvider
+	order     map[string][]string // provider names to ask first, by language
+	store     Store
+	index     *lyricSearch.Index
+	metrics   *metrics
+	errors    *errorLog
+	cache     *cache
+	stats     providerStatsSet
+}
+
+func newLyricsService(providers []lyricProvider, order map[string][]string, store Store, index *lyricSearch.Index, m *metrics, errs *errorLog) *lyricsService {
+	return &lyricsService{
+		providers: providers,
+		order:     order,
+		store:     store,
+		index:     index,
+		metrics:   m,
+		errors:    errs,
+		cache:     newCache(),
+		stats:     providerStatsSet{byName: make(map[string]*providerStats)},
+	}
+}
+
+// getCachedLyrics returns the lyrics of a track, or a not found message.
+func (l *lyricsService) getCachedLyrics(ctx context.Context, artist, title string) string {
+	lyrics, ok := l.lookup(ctx, artist, title)
+	if !ok {
+		return notFoundLyrics
+	}
+	return lyrics
+}
+
+// lookup returns the lyrics of a track and whether they were found.
+func (l *lyricsService) lookup(ctx context.Context, artist, title string) (string, bool) {
+	lyrics, _, ok := l.lookupMeta(ctx, artist, title)
+	return lyrics, ok
+}
+
+// lookupMeta is lookup that also tells where the lyrics came from (a
+// correction or the name of a provider) and their language.
+func (l *lyricsService) lookupMeta(ctx context.Context, artist, title string) (lyrics string, meta lyricMeta, ok bool) {
+	ctx, span := tracer.Start(ctx, "getCachedLyrics")
+	defer span.End()
+	log := logging.FromContext(ctx).With("artist", artist, "title", title)
+
+	if lyrics, meta, ok = l.lookupStored(ctx, artist, title); ok {
+		return lyrics, meta, true
+	}
+
+	// if not, then call get lyrics
+	lyrics, source, err := l.getLyrics(ctx, artist, title)
+	if err != nil {
+		return "", lyricMeta{}, false
+	}
+
+	// add new lyric to cache
+	log.Debug("Updating cache")
+	meta = l.put(artist, title, lyrics, source)
+	l.indexLyrics(artist, title, lyrics)
+	return lyrics, meta, true
+}
+
+// readThrough is lookupMeta for bulk reads such as exports. Lyrics
+// fetched from the providers are indexed but not cached, so that the
+// tracks of a long playlist don't push the ones being played out.
+func (l *lyricsService) readThrough(ctx context.Context, artist, title string) (string, lyricMeta, bool) {
+	if lyrics, meta, ok := l.lookupStored(ctx, artist, title); ok {
+		return lyrics, meta, true
+	}
+
+	lyrics, source, err := l.getLyrics(ctx, artist, title)
+	if err != nil {
+		return "", lyricMeta{}, false
+	}
+	l.indexLyrics(artist, title, lyrics)
+	return lyrics, lyricMeta{source, l.language(artist, title, lyrics)}, true
+}
+
+// lookupStored is lookupMeta without asking the providers: it returns
+// the approved correction of a track, or else its cached lyrics.
+func (l *lyricsService) lookupStored(ctx context.Context, artist, title string) (string, lyricMeta, bool) {
+	log := logging.FromContext(ctx).With("artist", artist, "title", title)
+
+	// approved corrections override whatever the providers return
+	rev, err := l.store.ApprovedRevision(ctx, artist, title)
+	if err == nil {
+		l.indexMissing(artist, title, rev.Text)
+		return rev.Text, lyricMeta{sourceCorrection, l.language(artist, title, rev.Text)}, true
+	} else if err != errNotFound {
+		log.Error("Getting approved revision", "err", err)
+		l.errors.record("store", err)
+	}
+
+	// look if in the cache, if yes - return
+	l.cache.mutex.Lock()
+	val, ok := l.cache.lSet.Get(artist, title)
+	meta := l.cache.meta[lyricTreeSet.Entry{Artist: artist, Title: title}]
+	if ok {
+		l.cache.hits++
+		l.metrics.cacheHits.Inc()
+	} else {
+		l.cache.misses++
+		l.metrics.cacheMisses.Inc()
+	}
+	l.cache.mutex.Unlock()
+	trace.SpanFromContext(ctx).SetAttributes(attribute.Bool("cache.hit", ok))
+	if ok {
+		log.Debug("Getting from cache")
+		l.indexMissing(artist, title, val)
+		return val, meta, true
+	}
+	return "", lyricMeta{}, false
+}
+
+// indexLyrics adds lyrics to the search index without their time tags,
+// so that line numbers of hits match the lines of lyricSync.Parse.
+func (l *lyricsService) indexLyrics(artist, title, lyrics string) {
+	l.index.Add(lyricSearch.Doc{Artist: artist, Title: title, Lyrics: lyricSync.Plain(lyrics)})
+}
+
+// indexMissing indexes lyrics the search index doesn't have, such as
+// corrections approved before it existed. Changed lyrics are indexed
+// where they change, on a fetch or an approval, not on every read.
+func (l *lyricsService) indexMissing(artist, title, lyrics string) {
+	if _, ok := l.index.Get(artist, title); !ok {
+		l.indexLyrics(artist, title, lyrics)
+	}
+}
+
+// put adds a lyric from source to the cache, with its language,
+// evicting the oldest one if it is full.
+func (l *lyricsService) put(artist, title, lyrics, source string) lyricMeta {
+	meta := lyricMeta{source, l.language(artist, title, lyrics)}
+	l.cache.mutex.Lock()
+	defer l.cache.mutex.Unlock()
+	l.cache.meta[lyricTreeSet.Entry{Artist: artist, Title: title}] = meta
+	if l.cache.lSet.Put(artist, title, lyrics) {
+		l.metrics.cacheEvictions.WithLabelValues("limit").Inc()
+		// forget the source and language of the evicted lyric
+		kept := make(map[lyricTreeSet.Entry]lyricMeta, len(l.cache.meta))
+		for _, e := range l.cache.lSet.Entries() {
+			kept[e] = l.cache.meta[e]
+		}
+		l.cache.meta = kept
+	}
+	return meta
+}
+
+// noteMarkets remembers the language the markets a track is sold in
+// hint at, for when its lyrics are fetched.
+func (l *lyricsService) noteMarkets(artist, title string, markets []string) {
+	guess := lyricLang.Guess(artist, title, markets)
+	if guess == lyricLang.Unknown {
+		return
+	}
+	l.cache.mutex.Lock()
+	defer l.cache.mutex.Unlock()
+	if len(l.cache.guesses) >= cacheLimit {
+		// guesses are cheap to make again
+		l.cache.guesses = make(map[lyricTreeSet.Entry]string)
+	}
+	l.cache.guesses[lyricTreeSet.Entry{Artist: artist, Title: title}] = guess
+}
+
+// guess returns the language of a track before its lyrics are known.
+func (l *lyricsService) guess(artist, title string) string {
+	l.cache.mutex.Lock()
+	guess, ok := l.cache.guesses[lyricTreeSet.Entry{Artist: artist, Title: title}]
+	l.cache.mutex.Unlock()
+	if ok {
+		return guess
+	}
+	return lyricLang.Guess(artist, title, nil)
+}
+
+// language detects the language of lyrics, falling back to the guess
+// for the track when they are too short to tell.
+func (l *lyricsService) language(artist, title, lyrics string) string {
+	if lang := lyricLang.Detect(lyricSync.Plain(lyrics)); lang != lyricLang.Unknown {
+		return lang
+	}
+	return l.guess(artist, title)
+}
+
+// providersFor returns the providers in the order configured for lang:
+// the ones named first, then the others in the default order.
+func (l *lyricsService) providersFor(lang string) []lyricProvider {
+	names := l.order[lang]
+	if len(names) == 0 {
+		return l.providers
+	}
+	ordered := make([]lyricProvider, 0, len(l.providers))
+	named := make(map[string]bool)
+	for _, name := range names {
+		for _, p := range l.providers {
+			if p.name == name && !named[name] {
+				ordered = append(ordered, p)
+				named[name] = true
+			}
+		}
+	}
+	for _, p := range l.providers {
+		if !named[p.name] {
+			ordered = append(ordered, p)
+		}
+	}
+	return ordered
+}
+
+// cached reports whether a lyric is in the cache, without
+// counting as a hit or a miss or keeping it from eviction.
+func (l *lyricsService) cached(artist, title string) bool {
+	l.cache.mutex.Lock()
+	defer l.cache.mutex.Unlock()
+	return l.cache.lSet.Contains(artist, title)
+}
+
+// getLyrics asks the providers in turn, in the order set for the
+// track's guessed language, returning the lyrics and the name of the
+// provider that had them.
+func (l *lyricsService) getLyrics(ctx context.Context, artist, title string) (string, string, error) {
+	for _, p := range l.providersFor(l.guess(artist, title)) {
+		if lyric, ok := l.fetch(ctx, p, artist, title); ok {
+			return lyric, p.name, nil
+		}
+	}
+	logging.FromContext(ctx).Info("Can't fetch lyrics", "artist", artist, "title", title)
+	return notFoundLyrics, "", errors.New("not found")
+}
+
+// evict removes a lyric from the cache and the search index on an
+// admin's request, usually because it is wrong.
+func (l *lyricsService) evict(artist, title string) {
+	l.index.Remove(artist, title)
+	l.cache.mutex.Lock()
+	defer l.cache.mutex.Unlock()
+	delete(l.cache.meta, lyricTreeSet.Entry{Artist: artist, Title: title})
+	if l.cache.lSet.Remove(artist, title) {
+		l.metrics.cacheEvictions.WithLabelValues("admin").Inc()
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"spotify-live-lyricist/pkg/lyricLang"
+	"spotify-live-lyricist/pkg/lyricSearch"
+)
+
+func TestProvidersFor(t *testing.T) {
+	index, _ := lyricSearch.Open("")
+	var providers []lyricProvider
+	for _, name := range []string{"genius", "musixmatch", "azlyrics"} {
+		providers = append(providers, lyricProvider{name, testLyrics})
+	}
+	order := map[string][]string{
+		"ko": {"musixmatch", "genius"},
+		"ja": {"azlyrics", "unknown", "azlyrics"},
+	}
+	l := newLyricsService(providers, order, newMemoryStore(), index, newMetrics(), newErrorLog())
+
+	tests := []struct {
+		lang string
+		want []string
+	}{
+		{"ko", []string{"musixmatch", "genius", "azlyrics"}},
+		{"ja", []string{"azlyrics", "genius", "musixmatch"}},
+		{"es", []string{"genius", "musixmatch", "azlyrics"}},
+		{lyricLang.Unknown, []string{"genius", "musixmatch", "azlyrics"}},
+		{"", []string{"genius", "musixmatch", "azlyrics"}},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, p := range l.providersFor(tt.lang) {
+			got = append(got, p.name)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: got %v, want %v", tt.lang, got, tt.want)
+		}
+	}
+
+	// the guess of a track comes from its markets once they are noted
+	if got := l.guess("Artist", "Canción"); got != lyricLang.Unknown {
+		t.Errorf("guess before markets: %q", got)
+	}
+	l.noteMarkets("Artist", "Canción", []string{"ES", "MX"})
+	if got := l.guess("Artist", "Canción"); got != "es" {
+		t.Errorf("guess from markets: %q", got)
+	}
+	if got := l.language("Artist", "Canción", "la la"); got != "es" {
+		t.Errorf("short lyrics: got %q, want the guess", got)
+	}
+	if got := l.language("Artist", "Canción", "사랑해 너를 정말로"); got != "ko" {
+		t.Errorf("Korean lyrics: got %q", got)
+	}
+}
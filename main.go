@@ -1,144 +1,238 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
-	"github.com/gomodule/redigo/redis"
-	"github.com/joho/godotenv"
-	"log"
-	"spotify-live-lyricist/pkg/lyricTreeSet"
-	"strconv"
-	"strings"
-	"time"
+	"log/slog"
+	"spotify-live-lyricist/pkg/artTheme"
+	"spotify-live-lyricist/pkg/config"
+	"spotify-live-lyricist/pkg/logging"
+	"spotify-live-lyricist/pkg/lyricSearch"
+	"spotify-live-lyricist/pkg/lyricSync"
+	"spotify-live-lyricist/pkg/lyricText"
 
-	"github.com/rhnvrm/lyric-api-go"
 	"github.com/zmb3/spotify"
-	"html/template"
 	"net/http"
 	"os"
-	"sync"
-)
-
-const cacheLimit = 300
-
-type cache struct {
-	lSet		*lyricTreeSet.LyricsSet
-	mutex		sync.Mutex
-}
-
-var (
-	port = 8080
-	tpl *template.Template
-	lyricCache *cache
-	clientId, secretKey, key, redirectURI string
-	spotifyAuth spotify.Authenticator
-	conn redis.Conn
+	"os/signal"
+	"syscall"
+	"time"
 )
 
 type Result struct {
 	Username				string
+	DisplayName, AvatarURL	string
 	DeviceType, DeviceName	string
-	Artist, Title 			string
-	Text					template.HTML
+	TrackID, Artist, Title	string
+	Album, AlbumArt			string
+	ReleaseYear				string
+	Progress, Duration		playTime
+	Explicit				bool
+	Theme					artTheme.Theme // from the album art
+	Clean					cleanMode
+	Text					string
+	Sections				[]sectionView
+	Collapse				bool // repeated sections
 }
 
-func init() {
-	// get env variables
-	p, _ := strconv.Atoi(os.Getenv("PORT"))
-	if p != 0 {
-		port = p
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "export" {
+		os.Exit(runExport(os.Args[2:]))
 	}
 
-	if os.Getenv("PRODUCTION") != "true" {
-		redirectURI = "http://localhost:8080/callback"
-		e := godotenv.Load()
-		if e != nil {
-			log.Fatal("Error loading .env file")
-		}
-	} else {
-		hostUrl := os.Getenv("HOST_URL")
-		redirectURI = hostUrl + "/callback"
-	}
+	configPath := flag.String("config", os.Getenv("CONFIG_FILE"), "optional YAML or TOML config file")
+	checkConfig := flag.Bool("check-config", false, "validate the configuration, print it redacted and exit")
+	flag.Parse()
 
-	clientId = os.Getenv("SPOTIFY_ID")
-	secretKey = os.Getenv("SPOTIFY_SECRET")
-	key = os.Getenv("ENCRYPTION_KEY")
+	cfg, err := config.Load(*configPath)
+	if *checkConfig && cfg != nil {
+		cfg.Print(os.Stdout)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if *checkConfig {
+		fmt.Println("Configuration OK")
+		return
+	}
 
-	// Configure Spotify
-	spotifyAuth = spotify.NewAuthenticator(redirectURI, spotify.ScopeUserReadCurrentlyPlaying, spotify.ScopeUserReadPlaybackState, spotify.ScopeUserModifyPlaybackState)
-	spotifyAuth.SetAuthInfo(clientId, secretKey)
-	tpl = template.Must(template.ParseGlob("templates/*"))
+	logger := logging.New(cfg.Production, os.Stderr)
+	slog.SetDefault(logger)
+	logger.Info("Configuration", "config", cfg.Redacted())
 
-	lyricCache = &cache{
-		lSet: lyricTreeSet.New(cacheLimit),
+	shutdownTracing, err := initTracing(cfg.TracesExporter, cfg.TracesFile)
+	if err != nil {
+		logger.Error("Configuring tracing", "err", err)
+		os.Exit(1)
 	}
+	defer shutdownTracing(context.Background())
 
-	lastSessionsCleaned = time.Now()
+	m := newMetrics()
+	store := newStore(cfg, m)
+	defer store.Close()
 
-	// Configure Redis
-	redisHost := os.Getenv("REDIS_HOST")
-	redisPort := os.Getenv("REDIS_PORT")
-	address := redisHost+":"+redisPort // "localhost:6379"
-	if address == ":" { // dev
-		address = ":6379"
+	index, err := lyricSearch.Open(cfg.SearchIndex)
+	if err != nil {
+		logger.Error("Opening search index", "path", cfg.SearchIndex, "err", err)
+		os.Exit(1)
 	}
-	pool := newPool(address)
-	conn = pool.Get()
-
-	tpl = template.Must(template.ParseGlob("templates/*.gohtml"))
-}
+	defer func() {
+		if err := index.Save(); err != nil {
+			logger.Error("Saving search index", "err", err)
+		}
+	}()
 
-func main() {
+	cleaner, err := loadProfanity(cfg)
+	if err != nil {
+		logger.Error("Loading profanity lists", "err", err)
+		os.Exit(1)
+	}
 
-	mux := http.NewServeMux()
-	mux.HandleFunc("/", playerHandler)
-	mux.HandleFunc("/authenticate", initAuth)
-	mux.HandleFunc("/callback", completeAuth)
-	mux.HandleFunc("/logout", logout)
-	mux.Handle("/favicon.ico", http.NotFoundHandler())
+	errs := newErrorLog()
+	app := NewApp(cfg, Deps{
+		Logger:    logger,
+		Store:     store,
+		Lyrics:    newLyricsService(defaultProviders(cfg.GeniusToken), cfg.ProviderOrder(), store, index, m, errs),
+		Spotify:   newSpotifyFactory(cfg.RedirectURI(), cfg.SpotifyID, cfg.SpotifySecret, nil, m),
+		Metrics:   m,
+		Errors:    errs,
+		Profanity: cleaner,
+	})
+
+	m.watchPollers(app.players)
+
+	// on SIGTERM stop accepting connections and wait for in-flight requests
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+	m.watchSessions(ctx, store)
+
+	if err := app.Run(ctx); err != nil {
+		logger.Error("Serving", "err", err)
+		os.Exit(1)
+	}
+}
 
-	fmt.Printf("Listening on port %d\n", port)
-	http.ListenAndServe(fmt.Sprintf(":%d", port), authMiddleware(mux))
+// newStore returns the store selected by the STORE setting.
+func newStore(cfg *config.Config, m *metrics) Store {
+	if cfg.Store == "memory" {
+		return newMemoryStore()
+	}
+	return newRedisStore(newPool(cfg.RedisAddress()), m)
 }
 
-func playerHandler(w http.ResponseWriter, r *http.Request) {
-	client, e := getClient(w, r)
+func (a *App) playerHandler(w http.ResponseWriter, r *http.Request) {
+	client, user, e := a.getUser(w, r)
 	if e != nil {
 		return
 	}
 
-	result, err := getSpotifyTrack(client, w)
+	result, err := a.getSpotifyTrack(r.Context(), client, w)
 	if err != nil {
-		http.Error(w, fmt.Sprintf(err.Error()), http.StatusInternalServerError)
+		a.errors.record("spotify", err)
+		a.spotifyError(w, r, err)
 		return
 	}
-
-	lyrics := getCachedLyrics(result.Artist, result.Title)
-	lyrics = strings.Replace(lyrics, "\n", "<br>", -1) // replace all newlines with proper html tag
-	result.Text = template.HTML(lyrics)
-	err = tpl.ExecuteTemplate(w, "index.gohtml", result)
+	result.Username = user.ID
+	result.DisplayName = user.DisplayName
+	result.AvatarURL = user.AvatarURL
+
+	result.Theme = a.theme(r.Context(), result.AlbumArt)
+	result.Clean = a.cleanMode(r)
+	lyrics := a.shownLyrics(r.Context(), result.Clean, result)
+	// the template escapes every line, whatever a provider sent
+	result.Text = lyricSync.Plain(lyrics)
+	result.Sections = sectionViews(lyricSync.Parse(lyrics), lyricText.Sections(result.Text))
+	result.Collapse = r.FormValue("repeats") == "collapse"
+	err = a.tpl.ExecuteTemplate(w, "index.gohtml", result)
 	if err != nil {
+		reqLogger(r).Error("Rendering player", "err", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 	}
 
 }
 
-func getSpotifyTrack(client *spotify.Client, w http.ResponseWriter) (*Result, error) {
-	var playerState *spotify.PlayerState
+// lyricsAPI returns as JSON the lyrics of the track named by artist and
+// title, or of the current track, with their source and language. In
+// clean mode, explicit=true marks a named track as explicit.
+func (a *App) lyricsAPI(w http.ResponseWriter, r *http.Request) {
+	client, _, err := a.getUser(w, r)
+	if err != nil {
+		return
+	}
+
+	artist, title := r.FormValue("artist"), r.FormValue("title")
+	explicit := r.FormValue("explicit") == "true"
+	if artist == "" && title == "" {
+		result, err := a.getSpotifyTrack(r.Context(), client, w)
+		if err != nil {
+			a.errors.record("spotify", err)
+			a.spotifyError(w, r, err)
+			return
+		}
+		artist, title, explicit = result.Artist, result.Title, result.Explicit
+	} else if artist == "" || title == "" {
+		http.Error(w, "Missing artist or title", http.StatusBadRequest)
+		return
+	}
+
+	lyrics, meta, ok := a.lyrics.lookupMeta(r.Context(), artist, title)
+	clean := a.cleanMode(r)
+	hidden := ok && clean.hides(explicit)
+	if hidden {
+		lyrics = ""
+	}
+	w.Header().Set("Content-Type", "application/json")
+	text := lyricSync.Plain(a.clean(clean, lyrics, meta.Language))
+	sections := lyricText.Sections(text)
+	if text == "" {
+		sections = []lyricText.Section{}
+	}
+	json.NewEncoder(w).Encode(struct {
+		Artist   string              `json:"artist"`
+		Title    string              `json:"title"`
+		Found    bool                `json:"found"`
+		Hidden   bool                `json:"hidden,omitempty"` // explicit, in clean mode
+		Lyrics   string              `json:"lyrics,omitempty"`
+		Sections []lyricText.Section `json:"sections"` // Start and End index the lines of Lyrics
+		Synced   bool                `json:"synced"`
+		Source   string              `json:"source,omitempty"`
+		Language string              `json:"language,omitempty"`
+	}{artist, title, ok, hidden, text, sections, lyricSync.Parse(lyrics).Synced, meta.Source, meta.Language})
+}
+
+func (a *App) getSpotifyTrack(ctx context.Context, client *spotify.Client, w http.ResponseWriter) (*Result, error) {
 	result := &Result{}
+	log := logging.FromContext(ctx)
 
-	user, e := client.CurrentUser()
+	done := a.startSpotifyCall(ctx, "PlayerState")
+	playerState, e := client.PlayerState()
+	done(e)
 	if e != nil {
-		fmt.Printf("Getting user: %s", e.Error())
+		log.Error("Getting player state", "err", e)
 		return nil, e
 	}
-	result.Username = user.ID
-
-	playerState, e = client.PlayerState()
+	a.prefetch.upcoming(ctx, client, playerState)
 	currPlaying := playerState.CurrentlyPlaying
 	if currPlaying.Playing == true && currPlaying.Item != nil {
 		result.Artist = currPlaying.Item.SimpleTrack.Artists[0].Name
 		result.Title = currPlaying.Item.SimpleTrack.Name
+		result.TrackID = string(currPlaying.Item.ID)
+		a.lyrics.index.SetURI(result.Artist, result.Title, string(currPlaying.Item.URI))
+		a.lyrics.noteMarkets(result.Artist, result.Title, currPlaying.Item.AvailableMarkets)
+		result.Album = currPlaying.Item.Album.Name
+		result.Explicit = currPlaying.Item.Explicit
+		if date := currPlaying.Item.Album.ReleaseDate; len(date) >= 4 {
+			result.ReleaseYear = date[:4] // "1981", "1981-12" or "1981-12-15"
+		}
+		result.Progress = playTime(time.Duration(currPlaying.Progress) * time.Millisecond)
+		result.Duration = playTime(time.Duration(currPlaying.Item.Duration) * time.Millisecond)
+		if images := currPlaying.Item.Album.Images; len(images) > 0 {
+			result.AlbumArt = images[0].URL // the largest
+		}
 		result.DeviceType = playerState.Device.Type
 		result.DeviceName = playerState.Device.Name
 		return result, nil
@@ -147,37 +241,3 @@ func getSpotifyTrack(client *spotify.Client, w http.ResponseWriter) (*Result, er
 	}
 
 }
-
-func getCachedLyrics(artist, title string) string {
-	// look if in the cache, if yes - return
-	val, ok := lyricCache.lSet.Get(artist, title)
-	if ok {
-		fmt.Println("Getting from cache")
-		return val
-	}
-
-	// if not, then call get lyrics
-	lyrics, err := getLyrics(artist, title)
-	if err != nil {
-		return lyrics // return "Not found"
-	}
-
-	// add new lyric to cache
-	fmt.Println("Updating cache")
-	lyricCache.mutex.Lock()
-	defer lyricCache.mutex.Unlock()
-	lyricCache.lSet.Put(artist, title, lyrics)
-
-	return lyrics
-}
-
-func getLyrics(artist, title string) (string, error) {
-	l := lyrics.New()
-	lyric, err := l.Search(artist, title)
-	if err != nil {
-		fmt.Printf("Can't fetch lyrics: %s\n", err.Error())
-		return "Lyrics not found :(", errors.New("not found")
-	}
-	return lyric, nil
-}
-
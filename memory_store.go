@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+type memoryEntry struct {
+	value   interface{}
+	expires time.Time
+}
+
+// memoryStore keeps everything in process memory. It is meant for tests
+// and for running locally without Redis; data is lost on restart.
+type memoryStore struct {
+	mutex          sync.Mutex
+	sessions       map[string]memoryEntry
+	states         map[string]memoryEntry
+	revisions      map[string]revision
+	trackRevisions map[string][]string
+	pending        []string
+	overrides      map[string]string
+	history        map[string][]play // by user ID, oldest first
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		sessions:       make(map[string]memoryEntry),
+		states:         make(map[string]memoryEntry),
+		revisions:      make(map[string]revision),
+		trackRevisions: make(map[string][]string),
+		overrides:      make(map[string]string),
+		history:        make(map[string][]play),
+	}
+}
+
+func (s *memoryStore) Ping(ctx context.Context) error { return nil }
+func (s *memoryStore) Close() error                   { return nil }
+
+// getUnexpired returns the entry of id unless it has expired.
+func getUnexpired(entries map[string]memoryEntry, id string) (interface{}, bool) {
+	e, ok := entries[id]
+	if !ok || time.Now().After(e.expires) {
+		delete(entries, id)
+		return nil, false
+	}
+	return e.value, true
+}
+
+func (s *memoryStore) SetSession(ctx context.Context, id string, sesh session) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.sessions[id] = memoryEntry{sesh, time.Now().Add(sessionLength * time.Second)}
+	return nil
+}
+
+func (s *memoryStore) GetSession(ctx context.Context, id string) (*session, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	v, ok := getUnexpired(s.sessions, id)
+	if !ok {
+		return nil, errNotFound
+	}
+	sesh := v.(session)
+	return &sesh, nil
+}
+
+func (s *memoryStore) DeleteSession(ctx context.Context, id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.sessions, id)
+	return nil
+}
+
+func (s *memoryStore) SessionIDs(ctx context.Context) ([]string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var ids []string
+	for id := range s.sessions {
+		if _, ok := getUnexpired(s.sessions, id); ok {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+func (s *memoryStore) GetSessions(ctx context.Context, ids []string) (map[string]session, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	sessions := make(map[string]session, len(ids))
+	for _, id := range ids {
+		if v, ok := getUnexpired(s.sessions, id); ok {
+			sessions[id] = v.(session)
+		}
+	}
+	return sessions, nil
+}
+
+func (s *memoryStore) SetState(ctx context.Context, id, state string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.states[id] = memoryEntry{state, time.Now().Add(sessionLength * time.Second)}
+	return nil
+}
+
+func (s *memoryStore) GetState(ctx context.Context, id string) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	v, ok := getUnexpired(s.states, id)
+	if !ok {
+		return "", errNotFound
+	}
+	return v.(string), nil
+}
+
+func (s *memoryStore) DeleteState(ctx context.Context, id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.states, id)
+	return nil
+}
+
+func (s *memoryStore) AddRevision(ctx context.Context, rev revision) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	key := trackRevisionsKey(rev.Artist, rev.Title)
+	s.trackRevisions[key] = append(s.trackRevisions[key], rev.ID)
+	rev.Version = len(s.trackRevisions[key])
+	s.revisions[rev.ID] = rev
+	s.pending = append(s.pending, rev.ID)
+	return nil
+}
+
+func (s *memoryStore) GetRevision(ctx context.Context, id string) (*revision, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	rev, ok := s.revisions[id]
+	if !ok {
+		return nil, errNotFound
+	}
+	return &rev, nil
+}
+
+func (s *memoryStore) PendingRevisions(ctx context.Context) ([]revision, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	revs := make([]revision, 0, len(s.pending))
+	for _, id := range s.pending {
+		revs = append(revs, s.revisions[id])
+	}
+	return revs, nil
+}
+
+func (s *memoryStore) ModerateRevision(ctx context.Context, rev revision, replace bool) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	pending := -1
+	for i, id := range s.pending {
+		if id == rev.ID {
+			pending = i
+			break
+		}
+	}
+	if pending < 0 {
+		return errModerated
+	}
+	key := lyricOverrideKey(rev.Artist, rev.Title)
+	if id, ok := s.overrides[key]; ok && rev.Status == revisionApproved && !replace && s.revisions[id].Version > rev.Version {
+		return errOutdated
+	}
+
+	s.revisions[rev.ID] = rev
+	s.pending = append(s.pending[:pending], s.pending[pending+1:]...)
+	if rev.Status == revisionApproved {
+		s.overrides[key] = rev.ID
+	}
+	return nil
+}
+
+func (s *memoryStore) ApprovedRevision(ctx context.Context, artist, title string) (*revision, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	id, ok := s.overrides[lyricOverrideKey(artist, title)]
+	if !ok {
+		return nil, errNotFound
+	}
+	rev := s.revisions[id]
+	return &rev, nil
+}
+
+func (s *memoryStore) AddPlays(ctx context.Context, userID string, plays []play) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	h := append(s.history[userID], plays...)
+	sort.SliceStable(h, func(i, j int) bool { return h[i].PlayedAt.Before(h[j].PlayedAt) })
+	if len(h) > historyLimit {
+		h = h[len(h)-historyLimit:]
+	}
+	s.history[userID] = h
+	return nil
+}
+
+func (s *memoryStore) History(ctx context.Context, userID string, limit int) ([]play, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	h := s.history[userID]
+	plays := make([]play, 0, limit)
+	for i := len(h) - 1; i >= 0 && len(plays) < limit; i-- {
+		plays = append(plays, h[i])
+	}
+	return plays, nil
+}
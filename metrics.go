@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metrics are registered on a registry of their own,
+// so that several apps can live in one process.
+type metrics struct {
+	registry *prometheus.Registry
+
+	httpRequests     *prometheus.CounterVec
+	httpDuration     *prometheus.HistogramVec
+	spotifyCalls     *prometheus.CounterVec
+	spotifyErrors    *prometheus.CounterVec
+	spotifyRetries   prometheus.Counter
+	spotifyLimited   *prometheus.CounterVec
+	spotifyCircuit   prometheus.Gauge
+	providerFetches  *prometheus.CounterVec
+	providerDuration *prometheus.HistogramVec
+	cacheHits        prometheus.Counter
+	cacheMisses      prometheus.Counter
+	cacheEvictions   *prometheus.CounterVec
+	prefetches       *prometheus.CounterVec
+	redisDuration    *prometheus.HistogramVec
+}
+
+func newMetrics() *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+
+		httpRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "sll_http_requests_total",
+			Help: "HTTP requests by route and status code.",
+		}, []string{"route", "status"}),
+
+		httpDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "sll_http_request_duration_seconds",
+			Help:    "HTTP request latency by route.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"route"}),
+
+		spotifyCalls: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "sll_spotify_api_calls_total",
+			Help: "Spotify Web API calls by method.",
+		}, []string{"call"}),
+
+		spotifyErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "sll_spotify_api_errors_total",
+			Help: "Failed Spotify Web API calls by method.",
+		}, []string{"call"}),
+
+		spotifyRetries: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "sll_spotify_retries_total",
+			Help: "Spotify requests retried after a 5xx or network error.",
+		}),
+
+		spotifyLimited: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "sll_spotify_rate_limited_total",
+			Help: "Spotify requests delayed or refused by rate limiting, by limit (global, user or spotify).",
+		}, []string{"limit"}),
+
+		spotifyCircuit: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "sll_spotify_circuit_state",
+			Help: "State of the Spotify circuit breaker: 0 closed, 1 half-open, 2 open.",
+		}),
+
+		providerFetches: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "sll_lyrics_provider_fetches_total",
+			Help: "Lyric fetches by provider and outcome.",
+		}, []string{"provider", "outcome"}),
+
+		providerDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "sll_lyrics_provider_fetch_duration_seconds",
+			Help:    "Lyric fetch latency by provider.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"provider"}),
+
+		cacheHits: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "sll_lyrics_cache_hits_total",
+			Help: "Lyrics found in the LyricsSet.",
+		}),
+
+		cacheMisses: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "sll_lyrics_cache_misses_total",
+			Help: "Lyrics missing from the LyricsSet.",
+		}),
+
+		cacheEvictions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "sll_lyrics_cache_evictions_total",
+			Help: "Lyrics removed from the LyricsSet, by reason (limit or admin).",
+		}, []string{"reason"}),
+
+		prefetches: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "sll_lyrics_prefetches_total",
+			Help: "Upcoming tracks prefetched, by outcome (fetched, cached, corrected or not_found).",
+		}, []string{"outcome"}),
+
+		redisDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "sll_redis_operation_duration_seconds",
+			Help:    "Redis command latency by command.",
+			Buckets: []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25},
+		}, []string{"command"}),
+	}
+
+	m.registry.MustRegister(m.httpRequests, m.httpDuration, m.spotifyCalls, m.spotifyErrors,
+		m.spotifyRetries, m.spotifyLimited, m.spotifyCircuit,
+		m.providerFetches, m.providerDuration, m.cacheHits, m.cacheMisses, m.cacheEvictions, m.prefetches,
+		m.redisDuration)
+	return m
+}
+
+// sessionCountInterval is how often the sessions are counted for
+// sll_active_sessions. Counting scans every session in the store, so
+// scrapes get the last count instead.
+const sessionCountInterval = time.Minute
+
+// watchSessions exports the number of unexpired sessions in the store,
+// counted until ctx is done.
+func (m *metrics) watchSessions(ctx context.Context, store Store) {
+	var count atomic.Int64
+	count.Store(-1) // not counted yet
+	m.registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "sll_active_sessions",
+		Help: "Unexpired sessions in the session store.",
+	}, func() float64 {
+		return float64(count.Load())
+	}))
+
+	go func() {
+		ticker := time.NewTicker(sessionCountInterval)
+		defer ticker.Stop()
+		for {
+			if ids, err := store.SessionIDs(ctx); err != nil {
+				count.Store(-1)
+			} else {
+				count.Store(int64(len(ids)))
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// watchPollers exports the number of running now-playing pollers.
+func (m *metrics) watchPollers(hub *pollerHub) {
+	m.registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "sll_now_playing_pollers",
+		Help: "Users whose player is being polled for live updates.",
+	}, func() float64 {
+		return float64(hub.size())
+	}))
+}
+
+func (m *metrics) handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// statusRecorder remembers the status code written by the wrapped handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Unwrap lets http.ResponseController reach the flusher and
+// deadlines of the wrapped writer.
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// middleware counts and times every request by the mux
+// pattern it was routed to, so that query strings and session
+// specific paths don't blow up the label cardinality.
+func (m *metrics) middleware(mux *http.ServeMux, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		_, route := mux.Handler(req)
+		if route == "" {
+			route = "unmatched"
+		}
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		start := time.Now()
+		next.ServeHTTP(rec, req)
+
+		m.httpDuration.WithLabelValues(route).Observe(time.Since(start).Seconds())
+		m.httpRequests.WithLabelValues(route, strconv.Itoa(rec.status)).Inc()
+	})
+}
+
+// observeSpotifyCall counts a Spotify Web API call and whether it failed.
+func (m *metrics) observeSpotifyCall(call string, err error) {
+	m.spotifyCalls.WithLabelValues(call).Inc()
+	if err != nil {
+		m.spotifyErrors.WithLabelValues(call).Inc()
+	}
+}
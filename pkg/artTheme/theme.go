@@ -0,0 +1,197 @@
+// Package artTheme picks the colours of a page from an album cover,
+// with a median cut quantizer.
+package artTheme
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/jpeg" // Spotify's covers
+	_ "image/png"
+	"math"
+	"sort"
+)
+
+// Theme holds CSS colours such as "#1d2b3a".
+type Theme struct {
+	Background string
+	Text       string
+	Accent     string
+}
+
+// Default is the theme of pages without a cover.
+var Default = Theme{Background: "#ffffff", Text: "#191414", Accent: "#1db954"}
+
+// Swatch is a colour of a palette and how many sampled pixels it stands for.
+type Swatch struct {
+	color.RGBA
+	Count int
+}
+
+const (
+	paletteSize = 8
+	maxSamples  = 10000 // pixels sampled from a cover, however large
+)
+
+// Decode reads a JPEG or PNG cover and returns its theme.
+func Decode(data []byte) (Theme, error) {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return Default, err
+	}
+	return FromImage(img), nil
+}
+
+// FromImage takes the background from the cover's most common colour,
+// the text from black or white, whichever reads better on it, and the
+// accent from the most colourful swatch that stands out from the
+// background.
+func FromImage(img image.Image) Theme {
+	palette := Palette(img, paletteSize)
+	if len(palette) == 0 {
+		return Default
+	}
+
+	bg := palette[0].RGBA
+	t := Theme{Background: hex(bg), Text: "#ffffff"}
+	if contrast(bg, color.RGBA{0, 0, 0, 255}) > contrast(bg, color.RGBA{255, 255, 255, 255}) {
+		t.Text = "#000000"
+	}
+
+	t.Accent = t.Text
+	best := 0.0
+	for _, s := range palette[1:] {
+		// links need a contrast of 3 to be told from the background
+		if contrast(bg, s.RGBA) < 3 {
+			continue
+		}
+		if score := saturation(s.RGBA) * math.Sqrt(float64(s.Count)); score > best {
+			t.Accent, best = hex(s.RGBA), score
+		}
+	}
+	return t
+}
+
+// Palette returns up to n colours of img, most common first.
+func Palette(img image.Image, n int) []Swatch {
+	b := img.Bounds()
+	step := 1
+	for (b.Dx()/step)*(b.Dy()/step) > maxSamples {
+		step++
+	}
+
+	var pixels []color.RGBA
+	for y := b.Min.Y; y < b.Max.Y; y += step {
+		for x := b.Min.X; x < b.Max.X; x += step {
+			c := color.RGBAModel.Convert(img.At(x, y)).(color.RGBA)
+			if c.A < 128 {
+				continue // transparent
+			}
+			pixels = append(pixels, c)
+		}
+	}
+	if len(pixels) == 0 {
+		return nil
+	}
+
+	boxes := [][]color.RGBA{pixels}
+	for len(boxes) < n {
+		// split the box with the widest range of a channel at its median
+		widest, channel, most := -1, 0, 0
+		for i, box := range boxes {
+			if len(box) < 2 {
+				continue
+			}
+			if c, r := widestChannel(box); r > most {
+				widest, channel, most = i, c, r
+			}
+		}
+		if widest < 0 {
+			break // every box is one colour
+		}
+		box := boxes[widest]
+		sort.Slice(box, func(i, j int) bool { return channelOf(box[i], channel) < channelOf(box[j], channel) })
+		boxes[widest] = box[:len(box)/2]
+		boxes = append(boxes, box[len(box)/2:])
+	}
+
+	palette := make([]Swatch, len(boxes))
+	for i, box := range boxes {
+		palette[i] = Swatch{average(box), len(box)}
+	}
+	sort.SliceStable(palette, func(i, j int) bool { return palette[i].Count > palette[j].Count })
+	return palette
+}
+
+func widestChannel(box []color.RGBA) (channel, width int) {
+	for c := 0; c < 3; c++ {
+		lo, hi := 255, 0
+		for _, p := range box {
+			v := channelOf(p, c)
+			if v < lo {
+				lo = v
+			}
+			if v > hi {
+				hi = v
+			}
+		}
+		if hi-lo > width {
+			channel, width = c, hi-lo
+		}
+	}
+	return channel, width
+}
+
+func channelOf(c color.RGBA, channel int) int {
+	switch channel {
+	case 0:
+		return int(c.R)
+	case 1:
+		return int(c.G)
+	}
+	return int(c.B)
+}
+
+func average(box []color.RGBA) color.RGBA {
+	var r, g, b int
+	for _, p := range box {
+		r, g, b = r+int(p.R), g+int(p.G), b+int(p.B)
+	}
+	n := len(box)
+	return color.RGBA{uint8(r / n), uint8(g / n), uint8(b / n), 255}
+}
+
+func hex(c color.RGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
+
+// luminance is the relative luminance of WCAG 2.
+func luminance(c color.RGBA) float64 {
+	linear := func(v uint8) float64 {
+		s := float64(v) / 255
+		if s <= 0.03928 {
+			return s / 12.92
+		}
+		return math.Pow((s+0.055)/1.055, 2.4)
+	}
+	return 0.2126*linear(c.R) + 0.7152*linear(c.G) + 0.0722*linear(c.B)
+}
+
+// contrast is the WCAG 2 contrast ratio of two colours, from 1 to 21.
+func contrast(a, b color.RGBA) float64 {
+	la, lb := luminance(a), luminance(b)
+	if la < lb {
+		la, lb = lb, la
+	}
+	return (la + 0.05) / (lb + 0.05)
+}
+
+func saturation(c color.RGBA) float64 {
+	max := math.Max(float64(c.R), math.Max(float64(c.G), float64(c.B)))
+	min := math.Min(float64(c.R), math.Min(float64(c.G), float64(c.B)))
+	if max == 0 {
+		return 0
+	}
+	return (max - min) / max
+}
@@ -0,0 +1,370 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+)
+
+const redacted = "[REDACTED]"
+
+// Config holds every setting of the app. Each field can be set in the
+// optional config file (by its yaml/toml key), in .env or in the
+// environment (by its env name); later sources override earlier ones.
+// Fields tagged secret are never printed.
+type Config struct {
+	Port          int      `env:"PORT" yaml:"port" toml:"port"`
+	Production    bool     `env:"PRODUCTION" yaml:"production" toml:"production"`
+	DevMode       bool     `env:"DEV_MODE" yaml:"dev_mode" toml:"dev_mode"`
+	HostURL       string   `env:"HOST_URL" yaml:"host_url" toml:"host_url"`
+	SpotifyID     string   `env:"SPOTIFY_ID" yaml:"spotify_id" toml:"spotify_id"`
+	SpotifySecret string   `env:"SPOTIFY_SECRET" yaml:"spotify_secret" toml:"spotify_secret" secret:"true"`
+	EncryptionKey string   `env:"ENCRYPTION_KEY" yaml:"encryption_key" toml:"encryption_key" secret:"true"`
+	Store         string   `env:"STORE" yaml:"store" toml:"store"`
+	RedisHost     string   `env:"REDIS_HOST" yaml:"redis_host" toml:"redis_host"`
+	RedisPort     string   `env:"REDIS_PORT" yaml:"redis_port" toml:"redis_port"`
+	AdminIDs      []string `env:"ADMIN_IDS" yaml:"admin_ids" toml:"admin_ids"`
+	GeniusToken   string   `env:"GENIUS_TOKEN" yaml:"genius_token" toml:"genius_token" secret:"true"`
+	SearchIndex   string   `env:"SEARCH_INDEX" yaml:"search_index" toml:"search_index"`
+
+	// LanguageProviders sets the order lyric providers are asked in for
+	// a language, as items such as "ko=genius/musixmatch". Providers left
+	// out are asked afterwards, in the default order.
+	LanguageProviders []string `env:"LANGUAGE_PROVIDERS" yaml:"language_providers" toml:"language_providers"`
+	// ProfanityLists replaces the word list clean mode masks for a
+	// language, as items such as "en=/etc/lyricist/en.txt".
+	ProfanityLists []string `env:"PROFANITY_LISTS" yaml:"profanity_lists" toml:"profanity_lists"`
+
+	PrefetchAhead   int `env:"PREFETCH_AHEAD" yaml:"prefetch_ahead" toml:"prefetch_ahead"`
+	PrefetchWorkers int `env:"PREFETCH_WORKERS" yaml:"prefetch_workers" toml:"prefetch_workers"`
+
+	PrintFontSize int `env:"PRINT_FONT_SIZE" yaml:"print_font_size" toml:"print_font_size"`
+	PrintColumns  int `env:"PRINT_COLUMNS" yaml:"print_columns" toml:"print_columns"`
+
+	ReadHeaderTimeout time.Duration `env:"SERVER_READ_HEADER_TIMEOUT" yaml:"read_header_timeout" toml:"read_header_timeout"`
+	ReadTimeout       time.Duration `env:"SERVER_READ_TIMEOUT" yaml:"read_timeout" toml:"read_timeout"`
+	WriteTimeout      time.Duration `env:"SERVER_WRITE_TIMEOUT" yaml:"write_timeout" toml:"write_timeout"`
+	IdleTimeout       time.Duration `env:"SERVER_IDLE_TIMEOUT" yaml:"idle_timeout" toml:"idle_timeout"`
+	ShutdownTimeout   time.Duration `env:"SERVER_SHUTDOWN_TIMEOUT" yaml:"shutdown_timeout" toml:"shutdown_timeout"`
+
+	TracesExporter string `env:"TRACES_EXPORTER" yaml:"traces_exporter" toml:"traces_exporter"`
+	TracesFile     string `env:"TRACES_FILE" yaml:"traces_file" toml:"traces_file"`
+}
+
+// ValidationError lists every problem found in the configuration.
+type ValidationError []string
+
+func (e ValidationError) Error() string {
+	return "invalid configuration:\n  - " + strings.Join(e, "\n  - ")
+}
+
+func defaults() *Config {
+	return &Config{
+		Port:              8080,
+		Store:             "redis",
+		RedisPort:         "6379",
+		SearchIndex:       "lyrics.idx",
+		PrefetchAhead:     5,
+		PrefetchWorkers:   3,
+		PrintFontSize:     12,
+		PrintColumns:      1,
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       30 * time.Second,
+		WriteTimeout:      60 * time.Second,
+		IdleTimeout:       120 * time.Second,
+		ShutdownTimeout:   30 * time.Second,
+	}
+}
+
+// Load reads the defaults, then the config file at path (if not empty),
+// then .env (if present), then the environment, and validates the result.
+// The config is returned along with a ValidationError so it can still be
+// printed.
+func Load(path string) (*Config, error) {
+	cfg := defaults()
+
+	if path != "" {
+		if err := cfg.loadFile(path); err != nil {
+			return nil, err
+		}
+	}
+
+	// godotenv never overrides variables that are already set
+	if err := godotenv.Load(); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("loading .env: %v", err)
+	}
+
+	var problems ValidationError
+	if err := cfg.loadEnv(); err != nil {
+		problems = append(problems, err.(ValidationError)...)
+	}
+	if err := cfg.Validate(); err != nil {
+		problems = append(problems, err.(ValidationError)...)
+	}
+	if len(problems) > 0 {
+		return cfg, problems
+	}
+	return cfg, nil
+}
+
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading config file: %v", err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(strings.NewReader(string(data)))
+		dec.KnownFields(true)
+		if err := dec.Decode(c); err != nil && err != io.EOF {
+			return fmt.Errorf("parsing %s: %v", path, err)
+		}
+	case ".toml":
+		md, err := toml.Decode(string(data), c)
+		if err != nil {
+			return fmt.Errorf("parsing %s: %v", path, err)
+		}
+		if undecoded := md.Undecoded(); len(undecoded) > 0 {
+			return fmt.Errorf("parsing %s: unknown key %q", path, undecoded[0].String())
+		}
+	default:
+		return fmt.Errorf("config file %s: expected a .yaml, .yml or .toml extension", path)
+	}
+	return nil
+}
+
+// loadEnv overrides every field whose env variable is set and not empty.
+func (c *Config) loadEnv() error {
+	var problems ValidationError
+
+	v := reflect.ValueOf(c).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name := t.Field(i).Tag.Get("env")
+		raw, ok := os.LookupEnv(name)
+		if !ok || raw == "" {
+			continue
+		}
+		if err := setField(v.Field(i), raw); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", name, err))
+		}
+	}
+
+	if len(problems) > 0 {
+		return problems
+	}
+	return nil
+}
+
+func setField(f reflect.Value, raw string) error {
+	switch f.Interface().(type) {
+	case string:
+		f.SetString(raw)
+	case int:
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", raw)
+		}
+		f.SetInt(int64(n))
+	case bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("%q is not true or false", raw)
+		}
+		f.SetBool(b)
+	case time.Duration:
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return fmt.Errorf("%q is not a duration such as 30s or 2m", raw)
+		}
+		f.SetInt(int64(d))
+	case []string:
+		var list []string
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		f.Set(reflect.ValueOf(list))
+	default:
+		return errors.New("unsupported field type")
+	}
+	return nil
+}
+
+// Validate checks that every required setting is present and sane.
+func (c *Config) Validate() error {
+	var problems ValidationError
+
+	if c.Port < 1 || c.Port > 65535 {
+		problems = append(problems, fmt.Sprintf("PORT must be between 1 and 65535, got %d", c.Port))
+	}
+	if c.SpotifyID == "" {
+		problems = append(problems, "SPOTIFY_ID is required (the client ID of your Spotify app)")
+	}
+	if c.SpotifySecret == "" {
+		problems = append(problems, "SPOTIFY_SECRET is required (the client secret of your Spotify app)")
+	}
+	if c.EncryptionKey == "" {
+		problems = append(problems, "ENCRYPTION_KEY is required to encrypt tokens stored in sessions")
+	}
+	if c.Production && c.DevMode {
+		problems = append(problems, "DEV_MODE must not be set in production")
+	}
+	if c.Production && c.HostURL == "" {
+		problems = append(problems, "HOST_URL is required in production to build the OAuth callback URL")
+	}
+	if c.HostURL != "" && !strings.HasPrefix(c.HostURL, "http://") && !strings.HasPrefix(c.HostURL, "https://") {
+		problems = append(problems, fmt.Sprintf("HOST_URL must start with http:// or https://, got %q", c.HostURL))
+	}
+	if _, err := strconv.Atoi(c.RedisPort); err != nil {
+		problems = append(problems, fmt.Sprintf("REDIS_PORT must be a number, got %q", c.RedisPort))
+	}
+	if c.Store != "redis" && c.Store != "memory" {
+		problems = append(problems, fmt.Sprintf("STORE must be redis or memory, got %q", c.Store))
+	}
+	if c.PrefetchAhead < 0 {
+		problems = append(problems, fmt.Sprintf("PREFETCH_AHEAD must not be negative, got %d", c.PrefetchAhead))
+	}
+	if c.PrefetchWorkers < 1 {
+		problems = append(problems, fmt.Sprintf("PREFETCH_WORKERS must be at least 1, got %d", c.PrefetchWorkers))
+	}
+	if c.PrintFontSize < 6 || c.PrintFontSize > 36 {
+		problems = append(problems, fmt.Sprintf("PRINT_FONT_SIZE must be between 6 and 36, got %d", c.PrintFontSize))
+	}
+	if c.PrintColumns < 1 || c.PrintColumns > 4 {
+		problems = append(problems, fmt.Sprintf("PRINT_COLUMNS must be between 1 and 4, got %d", c.PrintColumns))
+	}
+	for _, item := range c.LanguageProviders {
+		if _, _, err := parseLanguageProviders(item); err != nil {
+			problems = append(problems, fmt.Sprintf("LANGUAGE_PROVIDERS: %v", err))
+		}
+	}
+	for _, item := range c.ProfanityLists {
+		if lang, path, ok := strings.Cut(item, "="); !ok || strings.TrimSpace(lang) == "" || strings.TrimSpace(path) == "" {
+			problems = append(problems, fmt.Sprintf("PROFANITY_LISTS: %q should be a language and a file, such as en=words.txt", item))
+		}
+	}
+	switch c.TracesExporter {
+	case "", "none", "otlp", "stdout":
+	default:
+		problems = append(problems, fmt.Sprintf("TRACES_EXPORTER must be none, otlp or stdout, got %q", c.TracesExporter))
+	}
+	for name, d := range map[string]time.Duration{
+		"SERVER_READ_HEADER_TIMEOUT": c.ReadHeaderTimeout,
+		"SERVER_READ_TIMEOUT":        c.ReadTimeout,
+		"SERVER_WRITE_TIMEOUT":       c.WriteTimeout,
+		"SERVER_IDLE_TIMEOUT":        c.IdleTimeout,
+		"SERVER_SHUTDOWN_TIMEOUT":    c.ShutdownTimeout,
+	} {
+		if d < 0 {
+			problems = append(problems, fmt.Sprintf("%s must not be negative", name))
+		}
+	}
+
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return problems
+	}
+	return nil
+}
+
+// RedirectURI is the OAuth callback Spotify sends users back to.
+func (c *Config) RedirectURI() string {
+	if c.Production {
+		return strings.TrimSuffix(c.HostURL, "/") + "/callback"
+	}
+	return fmt.Sprintf("http://localhost:%d/callback", c.Port)
+}
+
+// ProviderOrder returns the provider names of LanguageProviders by
+// language code.
+func (c *Config) ProviderOrder() map[string][]string {
+	order := make(map[string][]string)
+	for _, item := range c.LanguageProviders {
+		if lang, names, err := parseLanguageProviders(item); err == nil {
+			order[lang] = names
+		}
+	}
+	return order
+}
+
+func parseLanguageProviders(item string) (string, []string, error) {
+	lang, list, ok := strings.Cut(item, "=")
+	lang = strings.ToLower(strings.TrimSpace(lang))
+	if !ok || lang == "" {
+		return "", nil, fmt.Errorf("%q should be a language and providers, such as ko=genius/musixmatch", item)
+	}
+	var names []string
+	for _, name := range strings.Split(list, "/") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return "", nil, fmt.Errorf("%q names no provider", item)
+	}
+	return lang, names, nil
+}
+
+// ProfanityFiles returns the files of ProfanityLists by language code.
+func (c *Config) ProfanityFiles() map[string]string {
+	files := make(map[string]string)
+	for _, item := range c.ProfanityLists {
+		if lang, path, ok := strings.Cut(item, "="); ok {
+			files[strings.ToLower(strings.TrimSpace(lang))] = strings.TrimSpace(path)
+		}
+	}
+	return files
+}
+
+// RedisAddress is the host:port of the Redis server.
+func (c *Config) RedisAddress() string {
+	return c.RedisHost + ":" + c.RedisPort
+}
+
+// Redacted returns every setting by its env name, with secrets masked.
+func (c *Config) Redacted() map[string]string {
+	values := make(map[string]string)
+
+	v := reflect.ValueOf(c).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		value := fmt.Sprint(v.Field(i).Interface())
+		if list, ok := v.Field(i).Interface().([]string); ok {
+			value = strings.Join(list, ",")
+		}
+		if field.Tag.Get("secret") == "true" && value != "" {
+			value = redacted
+		}
+		values[field.Tag.Get("env")] = value
+	}
+	return values
+}
+
+// Print writes the redacted settings as sorted NAME=value lines.
+func (c *Config) Print(w io.Writer) {
+	values := c.Redacted()
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		fmt.Fprintf(w, "%s=%s\n", name, values[name])
+	}
+}
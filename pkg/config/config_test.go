@@ -0,0 +1,215 @@
+package config
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// inTempDir runs the test in an empty directory, without any of the
+// Config env variables set, and writes files into it.
+func inTempDir(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	// t.Setenv restores the variables, including those .env sets
+	typ := reflect.TypeOf(Config{})
+	for i := 0; i < typ.NumField(); i++ {
+		name := typ.Field(i).Tag.Get("env")
+		t.Setenv(name, "")
+		os.Unsetenv(name)
+	}
+	return dir
+}
+
+func valid() *Config {
+	c := defaults()
+	c.SpotifyID, c.SpotifySecret, c.EncryptionKey = "id", "secret", "key"
+	return c
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	tests := []struct {
+		name, file, content string
+	}{
+		{"yaml", "config.yaml", "port: 1000\nstore: memory\nspotify_id: file-id\nspotify_secret: file-secret\nadmin_ids: [a, b]\nread_timeout: 5s\n"},
+		{"toml", "config.toml", "port = 1000\nstore = \"memory\"\nspotify_id = \"file-id\"\nspotify_secret = \"file-secret\"\nadmin_ids = [\"a\", \"b\"]\nread_timeout = \"5s\"\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := inTempDir(t, map[string]string{
+				tt.file: tt.content,
+				".env":  "PORT=2000\nSPOTIFY_ID=dotenv-id\nENCRYPTION_KEY=dotenv-key\n",
+			})
+			t.Setenv("PORT", "3000")
+
+			c, err := Load(filepath.Join(dir, tt.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := valid()
+			want.Port = 3000
+			want.Store = "memory"
+			want.SpotifyID = "dotenv-id"
+			want.SpotifySecret = "file-secret"
+			want.EncryptionKey = "dotenv-key"
+			want.AdminIDs = []string{"a", "b"}
+			want.ReadTimeout = 5 * time.Second
+			if !reflect.DeepEqual(c, want) {
+				t.Errorf("got %+v\nwant %+v", c, want)
+			}
+		})
+	}
+}
+
+func TestLoadFileErrors(t *testing.T) {
+	tests := []struct {
+		file, content, want string
+	}{
+		{"config.yaml", "prot: 1\n", "field prot not found"},
+		{"config.toml", "prot = 1\n", `unknown key "prot"`},
+		{"config.yaml", "port: [\n", "parsing"},
+		{"config.json", "{}", "expected a .yaml, .yml or .toml extension"},
+		{"missing.yaml", "", "reading config file"},
+	}
+	for _, tt := range tests {
+		files := map[string]string{tt.file: tt.content}
+		if tt.file == "missing.yaml" {
+			files = nil
+		}
+		dir := inTempDir(t, files)
+		if _, err := Load(filepath.Join(dir, tt.file)); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: got %v, want %q", tt.file, err, tt.want)
+		}
+	}
+}
+
+func TestLoadEnvTypes(t *testing.T) {
+	inTempDir(t, nil)
+	t.Setenv("SPOTIFY_ID", "id")
+	t.Setenv("SPOTIFY_SECRET", "secret")
+	t.Setenv("ENCRYPTION_KEY", "key")
+	t.Setenv("PORT", "eighty")
+	t.Setenv("DEV_MODE", "maybe")
+	t.Setenv("SERVER_READ_TIMEOUT", "10")
+	t.Setenv("ADMIN_IDS", " a, ,b ")
+
+	c, err := Load("")
+	problems, ok := err.(ValidationError)
+	if !ok {
+		t.Fatalf("got %v, want a ValidationError", err)
+	}
+	want := ValidationError{
+		`PORT: "eighty" is not a number`,
+		`DEV_MODE: "maybe" is not true or false`,
+		`SERVER_READ_TIMEOUT: "10" is not a duration such as 30s or 2m`,
+	}
+	for _, w := range want {
+		if !strings.Contains(problems.Error(), w) {
+			t.Errorf("got %v, want %q", problems, w)
+		}
+	}
+	if len(problems) != len(want) {
+		t.Errorf("got %d problems, want %d: %v", len(problems), len(want), problems)
+	}
+	if !reflect.DeepEqual(c.AdminIDs, []string{"a", "b"}) || c.Port != 8080 {
+		t.Errorf("got ADMIN_IDS %q and PORT %d", c.AdminIDs, c.Port)
+	}
+
+	var f float64
+	if err := setField(reflect.ValueOf(&f).Elem(), "1.5"); err == nil {
+		t.Errorf("float field: got no error")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		change func(*Config)
+		want   string
+	}{
+		{func(c *Config) { c.Port = 0 }, "PORT must be between 1 and 65535, got 0"},
+		{func(c *Config) { c.Port = 65536 }, "PORT must be between 1 and 65535, got 65536"},
+		{func(c *Config) { c.SpotifyID = "" }, "SPOTIFY_ID is required"},
+		{func(c *Config) { c.SpotifySecret = "" }, "SPOTIFY_SECRET is required"},
+		{func(c *Config) { c.EncryptionKey = "" }, "ENCRYPTION_KEY is required"},
+		{func(c *Config) { c.Production, c.DevMode, c.HostURL = true, true, "https://x" }, "DEV_MODE must not be set in production"},
+		{func(c *Config) { c.Production = true }, "HOST_URL is required in production"},
+		{func(c *Config) { c.HostURL = "example.com" }, `HOST_URL must start with http:// or https://, got "example.com"`},
+		{func(c *Config) { c.RedisPort = "redis" }, `REDIS_PORT must be a number, got "redis"`},
+		{func(c *Config) { c.Store = "disk" }, `STORE must be redis or memory, got "disk"`},
+		{func(c *Config) { c.PrefetchAhead = -1 }, "PREFETCH_AHEAD must not be negative, got -1"},
+		{func(c *Config) { c.PrefetchWorkers = 0 }, "PREFETCH_WORKERS must be at least 1, got 0"},
+		{func(c *Config) { c.PrintFontSize = 5 }, "PRINT_FONT_SIZE must be between 6 and 36, got 5"},
+		{func(c *Config) { c.PrintColumns = 5 }, "PRINT_COLUMNS must be between 1 and 4, got 5"},
+		{func(c *Config) { c.LanguageProviders = []string{"genius"} }, `LANGUAGE_PROVIDERS: "genius" should be a language and providers`},
+		{func(c *Config) { c.LanguageProviders = []string{"ko=/"} }, `LANGUAGE_PROVIDERS: "ko=/" names no provider`},
+		{func(c *Config) { c.ProfanityLists = []string{"en="} }, `PROFANITY_LISTS: "en=" should be a language and a file`},
+		{func(c *Config) { c.TracesExporter = "jaeger" }, `TRACES_EXPORTER must be none, otlp or stdout, got "jaeger"`},
+		{func(c *Config) { c.ReadHeaderTimeout = -1 }, "SERVER_READ_HEADER_TIMEOUT must not be negative"},
+		{func(c *Config) { c.ShutdownTimeout = -1 }, "SERVER_SHUTDOWN_TIMEOUT must not be negative"},
+	}
+
+	if err := valid().Validate(); err != nil {
+		t.Fatalf("valid config: %v", err)
+	}
+	for _, tt := range tests {
+		c := valid()
+		tt.change(c)
+		err := c.Validate()
+		problems, ok := err.(ValidationError)
+		if !ok || len(problems) != 1 || !strings.HasPrefix(problems[0], tt.want) {
+			t.Errorf("got %v, want %q", err, tt.want)
+		}
+	}
+}
+
+func TestRedacted(t *testing.T) {
+	c := valid()
+	c.SpotifySecret = "spotify-secret"
+	c.EncryptionKey = "encryption-key"
+	c.AdminIDs = []string{"a", "b"}
+
+	values := c.Redacted()
+	tests := map[string]string{
+		"SPOTIFY_SECRET": redacted,
+		"ENCRYPTION_KEY": redacted,
+		"GENIUS_TOKEN":   "",
+		"SPOTIFY_ID":     "id",
+		"ADMIN_IDS":      "a,b",
+		"PORT":           "8080",
+	}
+	for name, want := range tests {
+		if got := values[name]; got != want {
+			t.Errorf("%s: got %q, want %q", name, got, want)
+		}
+	}
+
+	var out bytes.Buffer
+	c.Print(&out)
+	for _, secret := range []string{"spotify-secret", "encryption-key"} {
+		if strings.Contains(out.String(), secret) {
+			t.Errorf("Print shows %q:\n%s", secret, out.String())
+		}
+	}
+	if !strings.Contains(out.String(), "SERVER_READ_TIMEOUT=30s\n") {
+		t.Errorf("Print lacks the read timeout:\n%s", out.String())
+	}
+}
@@ -0,0 +1,712 @@
+// Package fakeSpotify is an in-process fake of the parts of the Spotify
+// Accounts service and Web API the app uses, for tests that must not
+// reach the real Spotify. Its state is scripted from the test: which
+// user logs in, what they are playing, and which calls fail.
+package fakeSpotify
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zmb3/spotify"
+)
+
+// DefaultUser is the user created by New and logged in by /authorize.
+const DefaultUser = "fake-user"
+
+// Server fakes accounts.spotify.com and api.spotify.com. Point a client
+// at it with Client or Transport.
+type Server struct {
+	*httptest.Server
+
+	mu          sync.Mutex
+	users       map[string]*user
+	authorizeAs string
+	denyAuth    bool
+	codes       map[string]string // authorization code -> user ID
+	tokens      map[string]string // access token -> user ID
+	refresh     map[string]string // refresh token -> user ID
+	analyses    map[spotify.ID]spotify.AudioAnalysis
+	playlists   map[spotify.ID][]spotify.FullTrack
+	albums      map[spotify.ID][]spotify.FullTrack
+	failures    map[string][]failure
+	calls       map[string]int
+
+	// ClientID and ClientSecret, when set, must match the credentials
+	// sent to the token endpoint.
+	ClientID, ClientSecret string
+}
+
+type user struct {
+	profile  spotify.PrivateUser
+	device   *spotify.PlayerDevice
+	track    *spotify.FullTrack
+	context  spotify.PlaybackContext
+	playing  bool
+	progress time.Duration // at since
+	since    time.Time
+	recent   []spotify.RecentlyPlayedItem
+	saved    []spotify.FullTrack
+	top      []spotify.FullTrack
+}
+
+type failure struct {
+	status     int
+	retryAfter time.Duration
+}
+
+// New starts a fake with DefaultUser, who has a device but plays nothing.
+// Close it when done.
+func New() *Server {
+	s := &Server{
+		users:       make(map[string]*user),
+		authorizeAs: DefaultUser,
+		codes:       make(map[string]string),
+		tokens:      make(map[string]string),
+		refresh:     make(map[string]string),
+		analyses:    make(map[spotify.ID]spotify.AudioAnalysis),
+		playlists:   make(map[spotify.ID][]spotify.FullTrack),
+		albums:      make(map[spotify.ID][]spotify.FullTrack),
+		failures:    make(map[string][]failure),
+		calls:       make(map[string]int),
+	}
+	s.AddUser(DefaultUser, "Fake User")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/authorize", s.authorize)
+	mux.HandleFunc("/api/token", s.token)
+	mux.HandleFunc("/v1/me", s.api(s.me))
+	mux.HandleFunc("/v1/me/player", s.api(s.playerState))
+	mux.HandleFunc("/v1/me/player/currently-playing", s.api(s.currentlyPlaying))
+	mux.HandleFunc("/v1/me/player/recently-played", s.api(s.recentlyPlayed))
+	mux.HandleFunc("/v1/me/player/play", s.apiMethod(http.MethodPut, s.play))
+	mux.HandleFunc("/v1/me/player/seek", s.apiMethod(http.MethodPut, s.seek))
+	mux.HandleFunc("/v1/me/tracks", s.api(s.savedTracks))
+	mux.HandleFunc("/v1/me/top/tracks", s.api(s.topTracks))
+	mux.HandleFunc("/v1/audio-analysis/", s.api(s.audioAnalysis))
+	mux.HandleFunc("/v1/playlists/", s.api(s.playlistTracks))
+	mux.HandleFunc("/v1/albums/", s.api(s.albumTracks))
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// AddUser adds a user with an active device and nothing playing.
+// Scripting an unknown user adds it too.
+func (s *Server) AddUser(id, displayName string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.user(id).profile.DisplayName = displayName
+}
+
+// AuthorizeAs makes /authorize log in as the given user from now on.
+func (s *Server) AuthorizeAs(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.authorizeAs = id
+}
+
+// DenyAuth makes /authorize redirect back with error=access_denied,
+// as if the user refused to grant access.
+func (s *Server) DenyAuth(deny bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.denyAuth = deny
+}
+
+// Track builds a track for Play.
+func Track(id, artist, title string, duration time.Duration) spotify.FullTrack {
+	t := spotify.FullTrack{}
+	t.ID = spotify.ID(id)
+	t.URI = spotify.URI("spotify:track:" + id)
+	t.Name = title
+	t.Duration = int(duration / time.Millisecond)
+	t.Artists = []spotify.SimpleArtist{{Name: artist, ID: spotify.ID("artist-" + id)}}
+	t.Album.Name = title
+	return t
+}
+
+// Play starts a track from the beginning, outside of any playlist or
+// album. The track that was playing before goes to the recently played list.
+func (s *Server) Play(userID string, t spotify.FullTrack) {
+	s.PlayFrom(userID, "", t)
+}
+
+// PlayFrom plays a track of a playlist or album added with AddPlaylist
+// or AddAlbum, given its URI (e.g. "spotify:playlist:ID").
+func (s *Server) PlayFrom(userID string, contextURI spotify.URI, t spotify.FullTrack) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.user(userID).play(contextURI, t, 0)
+}
+
+// Pause stops the progress of the current track.
+func (s *Server) Pause(userID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u := s.user(userID)
+	u.progress = u.position()
+	u.since = time.Now()
+	u.playing = false
+}
+
+// Resume continues the current track.
+func (s *Server) Resume(userID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u := s.user(userID)
+	u.since = time.Now()
+	u.playing = u.track != nil
+}
+
+// Seek moves the current track to the given position.
+func (s *Server) Seek(userID string, position time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u := s.user(userID)
+	u.progress = position
+	u.since = time.Now()
+}
+
+// Disconnect removes the user's device, so the player endpoints answer
+// 204 No Content like Spotify does when nothing is active.
+func (s *Server) Disconnect(userID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u := s.user(userID)
+	u.device = nil
+	u.track = nil
+	u.playing = false
+}
+
+// AddPlaylist adds a playlist with the given tracks.
+func (s *Server) AddPlaylist(id spotify.ID, tracks ...spotify.FullTrack) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.playlists[id] = tracks
+}
+
+// Save adds tracks to the user's liked songs.
+func (s *Server) Save(userID string, tracks ...spotify.FullTrack) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u := s.user(userID)
+	u.saved = append(u.saved, tracks...)
+}
+
+// SetTopTracks sets the user's most listened tracks, most listened first.
+func (s *Server) SetTopTracks(userID string, tracks ...spotify.FullTrack) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.user(userID).top = tracks
+}
+
+// AddAlbum adds an album with the given tracks.
+func (s *Server) AddAlbum(id spotify.ID, tracks ...spotify.FullTrack) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.albums[id] = tracks
+}
+
+// SetAnalysis sets the audio analysis returned for a track.
+func (s *Server) SetAnalysis(id spotify.ID, a spotify.AudioAnalysis) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.analyses[id] = a
+}
+
+// Fail makes the next times calls to path (e.g. "/v1/me/player")
+// answer with status and a Spotify error body.
+func (s *Server) Fail(path string, status, times int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := 0; i < times; i++ {
+		s.failures[path] = append(s.failures[path], failure{status: status})
+	}
+}
+
+// RateLimit makes the next times calls to path answer 429 Too Many
+// Requests with the given Retry-After.
+func (s *Server) RateLimit(path string, retryAfter time.Duration, times int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := 0; i < times; i++ {
+		s.failures[path] = append(s.failures[path], failure{http.StatusTooManyRequests, retryAfter})
+	}
+}
+
+// ExpireTokens invalidates every access token handed out so far; API
+// calls then answer 401 until the token is refreshed.
+func (s *Server) ExpireTokens() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens = make(map[string]string)
+}
+
+// Calls reports how many requests reached path, failed ones included.
+func (s *Server) Calls(path string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls[path]
+}
+
+// Transport sends requests for Spotify's hosts to the fake and
+// everything else to http.DefaultTransport.
+func (s *Server) Transport() http.RoundTripper {
+	target, _ := url.Parse(s.URL)
+	return rewriter{target, http.DefaultTransport}
+}
+
+// Client is an HTTP client that talks to the fake instead of Spotify.
+// It does not follow redirects, so tests can inspect them.
+func (s *Server) Client() *http.Client {
+	return &http.Client{
+		Transport: s.Transport(),
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+type rewriter struct {
+	target *url.URL
+	next   http.RoundTripper
+}
+
+func (t rewriter) RoundTrip(req *http.Request) (*http.Response, error) {
+	switch req.URL.Host {
+	case "accounts.spotify.com", "api.spotify.com":
+		req = req.Clone(req.Context())
+		req.URL.Scheme = t.target.Scheme
+		req.URL.Host = t.target.Host
+		req.Host = ""
+	}
+	return t.next.RoundTrip(req)
+}
+
+// user returns the user with the given ID, creating it if needed
+// so scripts don't have to call AddUser first. s.mu must be held.
+func (s *Server) user(id string) *user {
+	u, ok := s.users[id]
+	if !ok {
+		u = &user{device: &spotify.PlayerDevice{ID: "fake-device", Active: true, Name: "Fake Device", Type: "Computer", Volume: 50}}
+		u.profile.ID = id
+		u.profile.DisplayName = id
+		u.profile.URI = spotify.URI("spotify:user:" + id)
+		u.profile.Country = "US"
+		u.profile.Product = "premium"
+		s.users[id] = u
+	}
+	return u
+}
+
+// play starts t at position, moving the current track to the recently
+// played list.
+func (u *user) play(contextURI spotify.URI, t spotify.FullTrack, position time.Duration) {
+	u.context = spotify.PlaybackContext{URI: contextURI}
+	if parts := strings.Split(string(contextURI), ":"); len(parts) >= 3 {
+		u.context.Type = parts[len(parts)-2]
+	}
+	if u.track != nil {
+		u.recent = append([]spotify.RecentlyPlayedItem{{Track: u.track.SimpleTrack, PlayedAt: time.Now()}}, u.recent...)
+	}
+	u.track = &t
+	u.playing = true
+	u.progress = position
+	u.since = time.Now()
+}
+
+// findTrack looks for a track by URI among the playlists, albums, liked
+// songs and tracks played so far. s.mu must be held.
+func (s *Server) findTrack(uri spotify.URI) (spotify.FullTrack, bool) {
+	var lists [][]spotify.FullTrack
+	for _, tracks := range s.playlists {
+		lists = append(lists, tracks)
+	}
+	for _, tracks := range s.albums {
+		lists = append(lists, tracks)
+	}
+	for _, u := range s.users {
+		lists = append(lists, u.saved)
+		if u.track != nil {
+			lists = append(lists, []spotify.FullTrack{*u.track})
+		}
+		for _, item := range u.recent {
+			lists = append(lists, []spotify.FullTrack{{SimpleTrack: item.Track}})
+		}
+	}
+	for _, tracks := range lists {
+		for _, t := range tracks {
+			if t.URI == uri {
+				return t, true
+			}
+		}
+	}
+	return spotify.FullTrack{}, false
+}
+
+// position is where playback is now, capped at the track's end.
+func (u *user) position() time.Duration {
+	p := u.progress
+	if u.playing {
+		p += time.Since(u.since)
+	}
+	if u.track != nil {
+		if d := time.Duration(u.track.Duration) * time.Millisecond; p > d {
+			p = d
+		}
+	}
+	return p
+}
+
+func (u *user) currentlyPlaying() spotify.CurrentlyPlaying {
+	return spotify.CurrentlyPlaying{
+		Timestamp:       time.Now().UnixNano() / int64(time.Millisecond),
+		Progress:        int(u.position() / time.Millisecond),
+		Playing:         u.playing,
+		Item:            u.track,
+		PlaybackContext: u.context,
+	}
+}
+
+func randomString() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError answers like the Web API does, which the spotify client decodes.
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, `{"error":{"status":%d,"message":%q}}`, status, message)
+}
+
+// authorize logs in as the AuthorizeAs user right away and sends the
+// browser back to redirect_uri with a code.
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	redirect, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil || q.Get("redirect_uri") == "" || q.Get("response_type") != "code" {
+		http.Error(w, "Invalid authorization request", http.StatusBadRequest)
+		return
+	}
+	if s.ClientID != "" && q.Get("client_id") != s.ClientID {
+		http.Error(w, "Invalid client", http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	v := redirect.Query()
+	if s.denyAuth {
+		v.Set("error", "access_denied")
+	} else {
+		code := randomString()
+		s.codes[code] = s.user(s.authorizeAs).profile.ID
+		v.Set("code", code)
+	}
+	s.mu.Unlock()
+
+	v.Set("state", q.Get("state"))
+	redirect.RawQuery = v.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+// token exchanges authorization codes and refresh tokens for access tokens.
+func (s *Server) token(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	r.ParseForm()
+
+	id, secret, ok := r.BasicAuth()
+	if !ok {
+		id, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	if s.ClientID != "" && (id != s.ClientID || secret != s.ClientSecret) {
+		tokenError(w, http.StatusUnauthorized, "invalid_client")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.calls[r.URL.Path]++
+	if s.fail(w, r.URL.Path) {
+		return
+	}
+
+	var userID string
+	switch r.PostForm.Get("grant_type") {
+	case "authorization_code":
+		userID, ok = s.codes[r.PostForm.Get("code")]
+		delete(s.codes, r.PostForm.Get("code")) // codes are single use
+	case "refresh_token":
+		userID, ok = s.refresh[r.PostForm.Get("refresh_token")]
+	default:
+		tokenError(w, http.StatusBadRequest, "unsupported_grant_type")
+		return
+	}
+	if !ok {
+		tokenError(w, http.StatusBadRequest, "invalid_grant")
+		return
+	}
+
+	access, refresh := randomString(), randomString()
+	s.tokens[access] = userID
+	s.refresh[refresh] = userID
+	writeJSON(w, map[string]interface{}{
+		"access_token":  access,
+		"token_type":    "Bearer",
+		"expires_in":    3600,
+		"refresh_token": refresh,
+		"scope":         "user-read-currently-playing user-read-playback-state user-modify-playback-state",
+	})
+}
+
+func tokenError(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, `{"error":%q}`, code)
+}
+
+// fail answers with the next scripted failure of path, if any. s.mu must be held.
+func (s *Server) fail(w http.ResponseWriter, path string) bool {
+	queue := s.failures[path]
+	if len(queue) == 0 {
+		return false
+	}
+	f := queue[0]
+	s.failures[path] = queue[1:]
+
+	if f.status == http.StatusTooManyRequests {
+		w.Header().Set("Retry-After", strconv.Itoa(int((f.retryAfter+time.Second-1)/time.Second)))
+	}
+	writeError(w, f.status, http.StatusText(f.status))
+	return true
+}
+
+// api authenticates the bearer token, counts the call and plays the
+// scripted failures before handing the request's user to h.
+func (s *Server) api(h func(http.ResponseWriter, *http.Request, *user)) http.HandlerFunc {
+	return s.apiMethod(http.MethodGet, h)
+}
+
+// apiMethod is api for endpoints called with another method than GET.
+func (s *Server) apiMethod(method string, h func(http.ResponseWriter, *http.Request, *user)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		path := r.URL.Path
+		for _, prefix := range []string{"/v1/audio-analysis/", "/v1/playlists/", "/v1/albums/"} {
+			if strings.HasPrefix(path, prefix) {
+				path = prefix
+			}
+		}
+		s.calls[path]++
+
+		userID, ok := s.tokens[strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")]
+		if !ok {
+			writeError(w, http.StatusUnauthorized, "The access token expired")
+			return
+		}
+		if s.fail(w, path) {
+			return
+		}
+
+		h(w, r, s.user(userID))
+	}
+}
+
+func (s *Server) me(w http.ResponseWriter, r *http.Request, u *user) {
+	writeJSON(w, u.profile)
+}
+
+func (s *Server) playerState(w http.ResponseWriter, r *http.Request, u *user) {
+	if u.device == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeJSON(w, spotify.PlayerState{
+		CurrentlyPlaying: u.currentlyPlaying(),
+		Device:           *u.device,
+		RepeatState:      "off",
+	})
+}
+
+// play starts the first of the given track URIs, which must be known
+// to the fake, or resumes when given none.
+func (s *Server) play(w http.ResponseWriter, r *http.Request, u *user) {
+	if u.device == nil {
+		writeError(w, http.StatusNotFound, "Player command failed: No active device found")
+		return
+	}
+
+	var opt struct {
+		URIs       []spotify.URI `json:"uris"`
+		PositionMs int           `json:"position_ms"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&opt); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	if len(opt.URIs) == 0 {
+		u.since = time.Now()
+		u.playing = u.track != nil
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	t, ok := s.findTrack(opt.URIs[0])
+	if !ok {
+		writeError(w, http.StatusBadRequest, "Invalid track uri: "+string(opt.URIs[0]))
+		return
+	}
+	u.play("", t, time.Duration(opt.PositionMs)*time.Millisecond)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) seek(w http.ResponseWriter, r *http.Request, u *user) {
+	position, err := strconv.Atoi(r.URL.Query().Get("position_ms"))
+	if err != nil || position < 0 {
+		writeError(w, http.StatusBadRequest, "Invalid position_ms")
+		return
+	}
+	if u.device == nil || u.track == nil {
+		writeError(w, http.StatusNotFound, "Player command failed: No active device found")
+		return
+	}
+	u.progress = time.Duration(position) * time.Millisecond
+	u.since = time.Now()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) currentlyPlaying(w http.ResponseWriter, r *http.Request, u *user) {
+	if u.device == nil || u.track == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeJSON(w, u.currentlyPlaying())
+}
+
+func (s *Server) recentlyPlayed(w http.ResponseWriter, r *http.Request, u *user) {
+	items := u.recent
+	if limit, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && limit < len(items) {
+		items = items[:limit]
+	}
+	writeJSON(w, spotify.RecentlyPlayedResult{Items: items})
+}
+
+func (s *Server) audioAnalysis(w http.ResponseWriter, r *http.Request, u *user) {
+	id := spotify.ID(strings.TrimPrefix(r.URL.Path, "/v1/audio-analysis/"))
+	a, ok := s.analyses[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, "analysis not found")
+		return
+	}
+	writeJSON(w, a)
+}
+
+// page cuts tracks by the request's limit and offset.
+func page(r *http.Request, tracks []spotify.FullTrack) (items []spotify.FullTrack, limit, offset int) {
+	limit, offset = 20, 0
+	if n, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && n > 0 {
+		limit = n
+	}
+	if n, err := strconv.Atoi(r.URL.Query().Get("offset")); err == nil && n > 0 {
+		offset = n
+	}
+	if offset > len(tracks) {
+		offset = len(tracks)
+	}
+	items = tracks[offset:]
+	if len(items) > limit {
+		items = items[:limit]
+	}
+	return items, limit, offset
+}
+
+// playlistTracks serves both a playlist, named after its ID, and its tracks.
+func (s *Server) playlistTracks(w http.ResponseWriter, r *http.Request, u *user) {
+	path := strings.TrimPrefix(r.URL.Path, "/v1/playlists/")
+	id := spotify.ID(strings.TrimSuffix(path, "/tracks"))
+	tracks, ok := s.playlists[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, "Not found.")
+		return
+	}
+	if !strings.HasSuffix(path, "/tracks") {
+		writeJSON(w, map[string]interface{}{"id": id, "name": string(id), "uri": "spotify:playlist:" + string(id)})
+		return
+	}
+
+	items, limit, offset := page(r, tracks)
+	result := spotify.PlaylistTrackPage{Tracks: make([]spotify.PlaylistTrack, len(items))}
+	for i, t := range items {
+		result.Tracks[i].Track = t
+	}
+	writeJSON(w, map[string]interface{}{"items": result.Tracks, "limit": limit, "offset": offset, "total": len(tracks)})
+}
+
+func (s *Server) albumTracks(w http.ResponseWriter, r *http.Request, u *user) {
+	id := spotify.ID(strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/v1/albums/"), "/tracks"))
+	tracks, ok := s.albums[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, "Not found.")
+		return
+	}
+
+	items, limit, offset := page(r, tracks)
+	simple := make([]spotify.SimpleTrack, len(items))
+	for i, t := range items {
+		simple[i] = t.SimpleTrack
+	}
+	writeJSON(w, map[string]interface{}{"items": simple, "limit": limit, "offset": offset, "total": len(tracks)})
+}
+
+func (s *Server) savedTracks(w http.ResponseWriter, r *http.Request, u *user) {
+	items, limit, offset := page(r, u.saved)
+	saved := make([]spotify.SavedTrack, len(items))
+	for i, t := range items {
+		saved[i].FullTrack = t
+	}
+	writeJSON(w, map[string]interface{}{"items": saved, "limit": limit, "offset": offset, "total": len(u.saved)})
+}
+
+func (s *Server) topTracks(w http.ResponseWriter, r *http.Request, u *user) {
+	items, limit, offset := page(r, u.top)
+	if items == nil {
+		items = []spotify.FullTrack{}
+	}
+	writeJSON(w, map[string]interface{}{"items": items, "limit": limit, "offset": offset, "total": len(u.top)})
+}
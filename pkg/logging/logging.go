@@ -0,0 +1,89 @@
+package logging
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"regexp"
+	"strings"
+)
+
+const redacted = "[REDACTED]"
+
+type contextKey struct{}
+
+// Attribute keys whose values are never written to the log.
+var secretKeys = map[string]bool{
+	"token":         true,
+	"access_token":  true,
+	"refresh_token": true,
+	"session":       true,
+	"session_id":    true,
+	"sid":           true,
+	"state":         true,
+	"cookie":        true,
+	"authorization": true,
+}
+
+// Secrets that sneak into free-form strings such as error messages.
+var secretPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)(bearer\s+)[A-Za-z0-9\-._~+/]+=*`),
+	regexp.MustCompile(`(?i)((?:access_token|refresh_token|code|state|session|sID)=)[^&\s"]+`),
+	regexp.MustCompile(`(?i)("(?:access_token|refresh_token)"\s*:\s*")[^"]*`),
+}
+
+// New creates a logger writing JSON in production and text otherwise.
+// Every attribute and message goes through Redact before it is written.
+func New(production bool, w io.Writer) *slog.Logger {
+	opts := &slog.HandlerOptions{
+		Level:       slog.LevelInfo,
+		ReplaceAttr: redactAttr,
+	}
+	if !production {
+		opts.Level = slog.LevelDebug
+		return slog.New(slog.NewTextHandler(w, opts))
+	}
+	return slog.New(slog.NewJSONHandler(w, opts))
+}
+
+func redactAttr(groups []string, a slog.Attr) slog.Attr {
+	if secretKeys[strings.ToLower(a.Key)] {
+		return slog.String(a.Key, redacted)
+	}
+	// everything in a group such as session is secret too
+	for _, g := range groups {
+		if secretKeys[strings.ToLower(g)] {
+			return slog.String(a.Key, redacted)
+		}
+	}
+	switch a.Value.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, Redact(a.Value.String()))
+	case slog.KindAny:
+		if err, ok := a.Value.Any().(error); ok {
+			return slog.String(a.Key, Redact(err.Error()))
+		}
+	}
+	return a
+}
+
+// Redact masks tokens, OAuth states and session IDs in s.
+func Redact(s string) string {
+	for _, re := range secretPatterns {
+		s = re.ReplaceAllString(s, "${1}"+redacted)
+	}
+	return s
+}
+
+// WithContext returns a copy of ctx carrying the logger.
+func WithContext(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, logger)
+}
+
+// FromContext returns the logger stored in ctx, or the default logger.
+func FromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(contextKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
@@ -0,0 +1,57 @@
+package logging
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+const secret = "s3cr3t-value"
+
+type logCase struct {
+	name string
+	log  func(*slog.Logger)
+}
+
+func TestRedaction(t *testing.T) {
+	tests := []logCase{
+		{"message", func(l *slog.Logger) { l.Info("GET /callback?code=" + secret) }},
+		{"bearer", func(l *slog.Logger) { l.Info("calling", "header", "Bearer "+secret) }},
+		{"query", func(l *slog.Logger) { l.Info("calling", "url", "https://x/?state="+secret+"&a=b") }},
+		{"json", func(l *slog.Logger) { l.Info("reply", "body", `{"access_token": "`+secret+`"}`) }},
+		{"error", func(l *slog.Logger) { l.Error("failed", "err", errors.New("refresh_token="+secret)) }},
+		{"group", func(l *slog.Logger) { l.Info("request", slog.Group("req", slog.Group("auth", "token", secret))) }},
+		{"secret group", func(l *slog.Logger) { l.Info("request", slog.Group("session", "id", secret)) }},
+		{"with group", func(l *slog.Logger) { l.WithGroup("req").Info("request", "cookie", secret) }},
+		{"with", func(l *slog.Logger) { l.With("sid", secret).Info("request") }},
+	}
+	for key := range secretKeys {
+		key := key
+		tests = append(tests, logCase{key, func(l *slog.Logger) { l.Info("request", key, secret, strings.ToUpper(key), secret) }})
+	}
+
+	for _, production := range []bool{false, true} {
+		for _, tt := range tests {
+			var out bytes.Buffer
+			tt.log(New(production, &out))
+			if strings.Contains(out.String(), secret) {
+				t.Errorf("%s (production %v): %s", tt.name, production, out.String())
+			}
+			if !strings.Contains(out.String(), redacted) {
+				t.Errorf("%s (production %v): nothing redacted: %s", tt.name, production, out.String())
+			}
+		}
+	}
+}
+
+func TestRedactKeepsOtherValues(t *testing.T) {
+	var out bytes.Buffer
+	New(true, &out).Info("playing", "track", "Song", slog.Group("req", "path", "/player"))
+	for _, want := range []string{`"track":"Song"`, `"req":{"path":"/player"}`} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("got %s, want %s", out.String(), want)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package lyricExport
+
+import (
+	"archive/zip"
+	"crypto/sha1"
+	"encoding/xml"
+	"fmt"
+	"html/template"
+	"io"
+	"time"
+)
+
+var opfTpl = template.Must(template.New("opf").Parse(`<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="id">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+    <dc:identifier id="id">{{.ID}}</dc:identifier>
+    <dc:title>{{.Book.Title}}</dc:title>{{with .Book.Language}}
+    <dc:language>{{.}}</dc:language>{{end}}
+    <meta property="dcterms:modified">{{.Modified}}</meta>
+  </metadata>
+  <manifest>
+    <item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>
+    <item id="lyrics" href="lyrics.xhtml" media-type="application/xhtml+xml"/>
+  </manifest>
+  <spine>
+    <itemref idref="lyrics"/>
+  </spine>
+</package>
+`))
+
+var navTpl = template.Must(template.New("nav").Parse(`<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops">
+<head><title>{{.Title}}</title></head>
+<body>
+  <nav epub:type="toc">
+    <h1>{{.Title}}</h1>
+    <ol>{{range $i, $t := .Found}}
+      <li><a href="lyrics.xhtml#t{{$i}}">{{$t.Title}}</a></li>{{end}}
+      <li><a href="lyrics.xhtml#summary">Summary</a></li>
+    </ol>
+  </nav>
+</body>
+</html>
+`))
+
+var chapterTpl = template.Must(template.New("chapter").Funcs(funcs).Parse(`<html xmlns="http://www.w3.org/1999/xhtml">
+<head><title>{{.Title}}</title></head>
+<body>
+  <h1>{{.Title}}</h1>{{range $i, $t := .Found}}
+  <section id="t{{$i}}">
+    <h2>{{$t.Title}}</h2>
+    <p><em>{{$t.Artist}}{{if $t.Album}} · {{$t.Album}}{{end}}</em></p>
+    <p>{{range lines $t.Plain}}{{.}}<br/>{{end}}</p>
+  </section>{{end}}
+  <section id="summary">
+    <h2>Summary</h2>
+    <p>{{len .Found}} of {{len .Tracks}} tracks have lyrics.</p>{{if .Truncated}}
+    <p>Truncated at {{len .Tracks}} tracks.</p>{{end}}{{with .Missing}}
+    <p>No lyrics found for:</p>
+    <ul>{{range .}}<li>{{.Artist}} - {{.Title}}</li>{{end}}</ul>{{end}}
+  </section>
+</body>
+</html>
+`))
+
+// The templates leave out the XML declaration, which html/template would escape.
+const containerXML = `<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+  <rootfiles>
+    <rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/>
+  </rootfiles>
+</container>
+`
+
+// EPUB writes the book as an EPUB 3 with one chapter holding every track.
+func EPUB(w io.Writer, b *Book) error {
+	zw := zip.NewWriter(w)
+
+	// the mimetype must come first and be stored uncompressed
+	f, err := zw.CreateHeader(&zip.FileHeader{Name: "mimetype", Method: zip.Store})
+	if err != nil {
+		return err
+	}
+	io.WriteString(f, "application/epub+zip")
+
+	created := b.Created
+	if created.IsZero() {
+		created = time.Now()
+	}
+	opf := struct {
+		Book     *Book
+		ID       string
+		Modified string
+	}{b, fmt.Sprintf("urn:sha1:%x", sha1.Sum([]byte(b.Title+created.String()))), created.UTC().Format("2006-01-02T15:04:05Z")}
+
+	files := []struct {
+		name string
+		tpl  *template.Template
+		data interface{}
+	}{
+		{"OEBPS/content.opf", opfTpl, opf},
+		{"OEBPS/nav.xhtml", navTpl, b},
+		{"OEBPS/lyrics.xhtml", chapterTpl, b},
+	}
+
+	if f, err = zw.Create("META-INF/container.xml"); err != nil {
+		return err
+	}
+	io.WriteString(f, xml.Header+containerXML)
+
+	for _, file := range files {
+		f, err := zw.Create(file.name)
+		if err != nil {
+			return err
+		}
+		io.WriteString(f, xml.Header)
+		if err := file.tpl.Execute(f, file.data); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
@@ -0,0 +1,209 @@
+// Package lyricExport writes the lyrics of a list of tracks as a single
+// booklet: Markdown, HTML, EPUB or a ZIP of LRC files.
+package lyricExport
+
+import (
+	"archive/zip"
+	"bufio"
+	"fmt"
+	"html/template"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+
+	"spotify-live-lyricist/pkg/lyricSync"
+)
+
+// Track is one song of a booklet. Tracks without lyrics are left out
+// of the lyrics and listed in the summary instead. Lyrics may carry
+// LRC time tags, which only the LRC files keep.
+type Track struct {
+	Artist, Title, Album string
+	Lyrics               string
+	Language             string // ISO 639-1 code of the lyrics, empty if unknown
+	Found                bool
+}
+
+// Plain returns the lyrics without time tags.
+func (t Track) Plain() string {
+	return lyricSync.Plain(t.Lyrics)
+}
+
+// Book is a titled list of tracks, in order.
+type Book struct {
+	Title     string
+	Created   time.Time
+	Tracks    []Track
+	Truncated bool // the source has more tracks than Tracks
+}
+
+// Formats lists the supported formats, by name.
+var Formats = map[string]Format{
+	"md":   {"text/markdown; charset=utf-8", ".md", Markdown},
+	"html": {"text/html; charset=utf-8", ".html", HTML},
+	"epub": {"application/epub+zip", ".epub", EPUB},
+	"lrc":  {"application/zip", ".zip", LRCZip},
+}
+
+// Format is a way to write a Book.
+type Format struct {
+	ContentType string
+	Extension   string
+	Write       func(w io.Writer, b *Book) error
+}
+
+// Found returns the tracks with lyrics.
+func (b *Book) Found() []Track {
+	var found []Track
+	for _, t := range b.Tracks {
+		if t.Found {
+			found = append(found, t)
+		}
+	}
+	return found
+}
+
+// Missing returns the tracks without lyrics.
+func (b *Book) Missing() []Track {
+	var missing []Track
+	for _, t := range b.Tracks {
+		if !t.Found {
+			missing = append(missing, t)
+		}
+	}
+	return missing
+}
+
+// Language returns the language most tracks with lyrics are in, the
+// one that got there first on a tie, or "" if none is known.
+func (b *Book) Language() string {
+	counts := make(map[string]int)
+	best := ""
+	for _, t := range b.Found() {
+		if t.Language == "" {
+			continue
+		}
+		counts[t.Language]++
+		if counts[t.Language] > counts[best] {
+			best = t.Language
+		}
+	}
+	return best
+}
+
+// Summary is a short report of how many tracks have lyrics
+// and which ones don't.
+func (b *Book) Summary() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%d of %d tracks have lyrics.\n", len(b.Found()), len(b.Tracks))
+	if b.Truncated {
+		fmt.Fprintf(&sb, "Truncated at %d tracks.\n", len(b.Tracks))
+	}
+	if missing := b.Missing(); len(missing) > 0 {
+		sb.WriteString("\nNo lyrics found for:\n")
+		for _, t := range missing {
+			fmt.Fprintf(&sb, "- %s - %s\n", t.Artist, t.Title)
+		}
+	}
+	return sb.String()
+}
+
+// Filename turns the book's title into a safe file name, without extension.
+func (b *Book) Filename() string {
+	return safeName(b.Title, "lyrics")
+}
+
+var unsafeChars = regexp.MustCompile(`[^\pL\pN\-_ .]+`)
+
+func safeName(s, fallback string) string {
+	s = strings.TrimSpace(unsafeChars.ReplaceAllString(s, ""))
+	if s == "" {
+		return fallback
+	}
+	return s
+}
+
+// Markdown writes the book with a heading per track.
+func Markdown(w io.Writer, b *Book) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "# %s\n\n", b.Title)
+	for _, t := range b.Found() {
+		fmt.Fprintf(bw, "## %s\n\n_%s_", t.Title, t.Artist)
+		if t.Album != "" {
+			fmt.Fprintf(bw, " · %s", t.Album)
+		}
+		bw.WriteString("\n\n")
+		for _, line := range strings.Split(t.Plain(), "\n") {
+			// two trailing spaces keep the line breaks of the verse
+			fmt.Fprintf(bw, "%s  \n", line)
+		}
+		bw.WriteString("\n")
+	}
+	fmt.Fprintf(bw, "---\n\n%s", b.Summary())
+	return bw.Flush()
+}
+
+var funcs = template.FuncMap{
+	"lines": func(s string) []string { return strings.Split(s, "\n") },
+}
+
+var htmlTpl = template.Must(template.New("book").Funcs(funcs).Parse(`<!DOCTYPE html>
+<html{{with .Language}} lang="{{.}}"{{end}}>
+<head>
+    <meta charset="UTF-8">
+    <title>{{.Title}}</title>
+    <style>
+        body { font-family: Georgia, serif; max-width: 40em; margin: auto; }
+        section { page-break-after: always; }
+        .by { font-style: italic; }
+    </style>
+</head>
+<body>
+    <h1>{{.Title}}</h1>
+    <ol>{{range $i, $t := .Found}}<li><a href="#t{{$i}}">{{$t.Title}}</a> - {{$t.Artist}}</li>{{end}}</ol>
+    {{range $i, $t := .Found}}
+    <section id="t{{$i}}">
+        <h2>{{$t.Title}}</h2>
+        <p class="by">{{$t.Artist}}{{if $t.Album}} · {{$t.Album}}{{end}}</p>
+        <p>{{range lines $t.Plain}}{{.}}<br>{{end}}</p>
+    </section>
+    {{end}}
+    <h2>Summary</h2>
+    <p>{{len .Found}} of {{len .Tracks}} tracks have lyrics.</p>
+    {{if .Truncated}}<p>Truncated at {{len .Tracks}} tracks.</p>{{end}}
+    {{with .Missing}}<p>No lyrics found for:</p>
+    <ul>{{range .}}<li>{{.Artist}} - {{.Title}}</li>{{end}}</ul>{{end}}
+</body>
+</html>
+`))
+
+// HTML writes the book as a single page with a table of contents.
+func HTML(w io.Writer, b *Book) error {
+	return htmlTpl.Execute(w, b)
+}
+
+// LRCZip writes a ZIP holding an LRC file per track with lyrics, and
+// MISSING.txt with the summary. Lines keep their time tags where the
+// provider had them; lyrics without any are written as plain lines.
+func LRCZip(w io.Writer, b *Book) error {
+	zw := zip.NewWriter(w)
+	for i, t := range b.Found() {
+		f, err := zw.Create(fmt.Sprintf("%02d - %s - %s.lrc", i+1, safeName(t.Artist, "Unknown"), safeName(t.Title, "Untitled")))
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(f, "[ar:%s]\n[ti:%s]\n", t.Artist, t.Title)
+		if t.Album != "" {
+			fmt.Fprintf(f, "[al:%s]\n", t.Album)
+		}
+		fmt.Fprintf(f, "\n%s\n", lyricSync.Parse(t.Lyrics).LRC())
+	}
+
+	f, err := zw.Create("MISSING.txt")
+	if err != nil {
+		return err
+	}
+	io.WriteString(f, b.Summary())
+	return zw.Close()
+}
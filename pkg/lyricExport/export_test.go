@@ -0,0 +1,123 @@
+package lyricExport
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestSummary(t *testing.T) {
+	found := Track{Artist: "Artist", Title: "Song", Lyrics: "la la", Found: true}
+	missing := Track{Artist: "Nobody", Title: "Missing"}
+
+	tests := []struct {
+		name string
+		book Book
+		want string
+	}{
+		{"all found", Book{Tracks: []Track{found}}, "1 of 1 tracks have lyrics.\n"},
+		{"missing", Book{Tracks: []Track{found, missing}}, "1 of 2 tracks have lyrics.\n\nNo lyrics found for:\n- Nobody - Missing\n"},
+		{"truncated", Book{Tracks: []Track{found, found}, Truncated: true}, "2 of 2 tracks have lyrics.\nTruncated at 2 tracks.\n"},
+	}
+	for _, tt := range tests {
+		if got := tt.book.Summary(); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestLanguage(t *testing.T) {
+	track := func(lang string, found bool) Track {
+		return Track{Title: "Song", Lyrics: "la", Language: lang, Found: found}
+	}
+
+	tests := []struct {
+		name   string
+		tracks []Track
+		want   string
+	}{
+		{"none", nil, ""},
+		{"unknown", []Track{track("", true)}, ""},
+		{"most", []Track{track("ko", true), track("en", true), track("en", true)}, "en"},
+		{"tie", []Track{track("es", true), track("en", true)}, "es"},
+		{"found only", []Track{track("ko", false), track("ko", false), track("es", true)}, "es"},
+	}
+	for _, tt := range tests {
+		b := Book{Tracks: tt.tracks}
+		if got := b.Language(); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestEPUBLanguage(t *testing.T) {
+	tests := []struct {
+		lang, want string
+	}{
+		{"ko", "<dc:language>ko</dc:language>"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		b := &Book{Title: "Book", Tracks: []Track{{Title: "Song", Lyrics: "la", Language: tt.lang, Found: true}}}
+		if err := EPUB(&buf, b); err != nil {
+			t.Fatal(err)
+		}
+		zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		f, err := zr.Open("OEBPS/content.opf")
+		if err != nil {
+			t.Fatal(err)
+		}
+		opf, _ := io.ReadAll(f)
+		if tt.want != "" && !strings.Contains(string(opf), tt.want) {
+			t.Errorf("%q: content.opf lacks %s\n%s", tt.lang, tt.want, opf)
+		}
+		if tt.want == "" && strings.Contains(string(opf), "dc:language") {
+			t.Errorf("unknown language written\n%s", opf)
+		}
+	}
+}
+
+func TestTimedLyrics(t *testing.T) {
+	b := &Book{Title: "Book", Tracks: []Track{
+		{Artist: "Sync", Title: "Song", Lyrics: "[ar:Sync]\n[00:01.00]first verse\n[00:10.50]chorus", Found: true},
+		{Artist: "Plain", Title: "Song", Lyrics: "just words", Found: true},
+	}}
+
+	var buf bytes.Buffer
+	if err := LRCZip(&buf, b); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"01 - Sync - Song.lrc":  "[ar:Sync]\n[ti:Song]\n\n[00:01.00]first verse\n[00:10.50]chorus\n",
+		"02 - Plain - Song.lrc": "[ar:Plain]\n[ti:Song]\n\njust words\n",
+	}
+	for name, content := range want {
+		f, err := zr.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := io.ReadAll(f); string(got) != content {
+			t.Errorf("%s: got %q, want %q", name, got, content)
+		}
+	}
+
+	for name, format := range map[string]func(io.Writer, *Book) error{"md": Markdown, "html": HTML} {
+		buf.Reset()
+		if err := format(&buf, b); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(buf.String(), "first verse") || strings.Contains(buf.String(), "[00:") || strings.Contains(buf.String(), "[ar:") {
+			t.Errorf("%s keeps tags:\n%s", name, buf.String())
+		}
+	}
+}
@@ -0,0 +1,157 @@
+// Package lyricLang guesses the language of lyrics: from the script for
+// languages with their own, and from the most common words for the
+// languages written in the Latin alphabet.
+package lyricLang
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Unknown is returned when the text is too short or too mixed to tell.
+const Unknown = "und"
+
+// minWords is how many common words a Latin-script text needs to have
+// for its language to be guessed.
+const minWords = 3
+
+// scripts map a script to its language, checked in order: Japanese
+// mixes kana with Han characters, so kana must come before Han.
+var scripts = []struct {
+	table *unicode.RangeTable
+	lang  string
+}{
+	{unicode.Hangul, "ko"},
+	{unicode.Hiragana, "ja"},
+	{unicode.Katakana, "ja"},
+	{unicode.Han, "zh"},
+	{unicode.Cyrillic, "ru"},
+	{unicode.Greek, "el"},
+	{unicode.Arabic, "ar"},
+	{unicode.Hebrew, "he"},
+	{unicode.Thai, "th"},
+	{unicode.Devanagari, "hi"},
+}
+
+// Detect returns the ISO 639-1 code of the language of text, or Unknown.
+func Detect(text string) string {
+	letters := 0
+	counts := make(map[string]int)
+	for _, r := range text {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		letters++
+		for _, s := range scripts {
+			if unicode.Is(s.table, r) {
+				counts[s.lang]++
+				break
+			}
+		}
+	}
+	if letters == 0 {
+		return Unknown
+	}
+	if counts["ja"] > 0 {
+		counts["ja"] += counts["zh"]
+		delete(counts, "zh")
+	}
+	best, most := "", 0
+	for lang, n := range counts {
+		if n > most {
+			best, most = lang, n
+		}
+	}
+	// a script language when at least a third of the letters say so,
+	// since lyrics often mix in English
+	if most*3 >= letters {
+		return best
+	}
+	return detectLatin(text)
+}
+
+// detectLatin picks the language whose common words make up most of text.
+func detectLatin(text string) string {
+	hits := make(map[string]int)
+	for _, w := range Words(text) {
+		for lang, words := range stopwords {
+			if words[w] {
+				hits[lang]++
+			}
+		}
+	}
+
+	best, most, second := Unknown, 0, 0
+	for lang, n := range hits {
+		switch {
+		case n > most:
+			best, most, second = lang, n, most
+		case n > second:
+			second = n
+		}
+	}
+	if most < minWords || most == second {
+		return Unknown
+	}
+	return best
+}
+
+// Words splits text into lowercase words, dropping apostrophes.
+func Words(text string) []string {
+	var ws []string
+	for _, f := range strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r) && r != '\'' && r != '’'
+	}) {
+		if w := strings.ToLower(strings.NewReplacer("'", "", "’", "").Replace(f)); w != "" {
+			ws = append(ws, w)
+		}
+	}
+	return ws
+}
+
+// IsStopword reports whether w is one of the most common words of any
+// known language, which say little about a song.
+func IsStopword(w string) bool {
+	for _, words := range stopwords {
+		if words[w] {
+			return true
+		}
+	}
+	return false
+}
+
+// Name is the English name of a language code.
+func Name(code string) string {
+	if name, ok := names[code]; ok {
+		return name
+	}
+	return "Unknown"
+}
+
+var names = map[string]string{
+	"en": "English", "es": "Spanish", "pt": "Portuguese", "fr": "French",
+	"de": "German", "it": "Italian", "nl": "Dutch", "sv": "Swedish",
+	"ko": "Korean", "ja": "Japanese", "zh": "Chinese", "ru": "Russian",
+	"el": "Greek", "ar": "Arabic", "he": "Hebrew", "th": "Thai", "hi": "Hindi",
+}
+
+func set(words string) map[string]bool {
+	m := make(map[string]bool)
+	for _, w := range strings.Fields(words) {
+		m[w] = true
+	}
+	return m
+}
+
+// stopwords are the most frequent words of each Latin-script language,
+// without the apostrophes Words drops.
+var stopwords = map[string]map[string]bool{
+	"en": set("the and you i to a me my it in is of that your on im dont all be we for with this but so what no know like just love oh when can its up do now"),
+	"es": set("el la de que y en un una los las por con no es mi me te tu lo para se yo como más mas pero si cuando eres quiero amor estoy"),
+	"pt": set("o a de que e do da em um uma os as não nao com eu meu minha você voce se por mais mas quando amor tudo estou sou"),
+	"fr": set("le la les de des et un une je tu il elle que qui ne pas est mon ma mes dans pour avec sur moi toi cest jai suis"),
+	"de": set("der die das und ich du nicht ist ein eine zu mit mich dich mir dir auf wie sich wir sie es bin kein noch"),
+	"it": set("il lo la di che e un una non sono mi ti per con io tu come ma cosa più piu quando amore questo sei"),
+	"nl": set("de het een en ik je jij niet is dat van in op met mij mijn wat zo maar voor ben naar"),
+	"sv": set("och att det som en är jag du inte på med för mig dig min har var vi så men till"),
+}
@@ -0,0 +1,87 @@
+package lyricLang
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		name, text, want string
+	}{
+		{"empty", "", Unknown},
+		{"no letters", "123 !?", Unknown},
+		{"Korean", "사랑해 너를 정말로", "ko"},
+		{"Korean with some English", "baby 사랑해 너를 정말로", "ko"},
+		{"Japanese kana and kanji", "君のことが好きだ", "ja"},
+		{"Japanese katakana", "ラブソング", "ja"},
+		{"Chinese", "我爱你中国", "zh"},
+		{"Russian", "я тебя люблю", "ru"},
+		{"English", "I know you love me and the night is young", "en"},
+		{"Spanish", "yo no sé por qué te quiero tanto mi amor", "es"},
+		{"French", "je ne sais pas pourquoi tu es avec moi", "fr"},
+		{"too few common words", "despacito baby", Unknown},
+		{"no common words", "la la la", Unknown},
+		{"mostly English with a little Korean", "I know you love me and the night is young 사랑", "en"},
+	}
+	for _, tt := range tests {
+		if got := Detect(tt.text); got != tt.want {
+			t.Errorf("%s: Detect(%q) = %q, want %q", tt.name, tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestGuess(t *testing.T) {
+	tests := []struct {
+		name          string
+		artist, title string
+		markets       []string
+		want          string
+	}{
+		{"empty", "", "", nil, Unknown},
+		{"Korean script", "아이유", "좋은 날", []string{"US"}, "ko"},
+		{"too little script for the markets", "아이유", "Good Day", []string{"US"}, "en"},
+		{"Japanese script", "ヨルシカ", "ただ君に晴れ", nil, "ja"},
+		{"kanji alone read as Chinese", "米津玄師", "Lemon", nil, "zh"},
+		{"one Spanish market", "Artist", "Song", []string{"MX"}, "es"},
+		{"Spanish markets", "Artist", "Song", []string{"ES", "AR", "CL"}, "es"},
+		{"unknown markets ignored", "Artist", "Song", []string{"KR", "CH", "BE"}, "ko"},
+		{"mixed markets", "Artist", "Song", []string{"US", "ES"}, Unknown},
+		{"only unknown markets", "Artist", "Song", []string{"CH"}, Unknown},
+	}
+	for _, tt := range tests {
+		if got := Guess(tt.artist, tt.title, tt.markets); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestWords(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"", nil},
+		{"Don't STOP, me-now 2", []string{"dont", "stop", "me", "now", "2"}},
+		{"qué pasó’s", []string{"qué", "pasós"}},
+		{"사랑해 너를", []string{"사랑해", "너를"}},
+	}
+	for _, tt := range tests {
+		if got := Words(tt.text); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Words(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestStopwordsAndNames(t *testing.T) {
+	for w, want := range map[string]bool{"the": true, "que": true, "und": true, "darkness": false, "": false} {
+		if got := IsStopword(w); got != want {
+			t.Errorf("IsStopword(%q) = %v, want %v", w, got, want)
+		}
+	}
+	for code, want := range map[string]string{"ko": "Korean", "es": "Spanish", Unknown: "Unknown", "": "Unknown"} {
+		if got := Name(code); got != want {
+			t.Errorf("Name(%q) = %q, want %q", code, got, want)
+		}
+	}
+}
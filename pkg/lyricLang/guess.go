@@ -0,0 +1,46 @@
+package lyricLang
+
+// Guess returns the likely language of a song before its lyrics are
+// known: from the script of its artist and title, or else from the
+// markets it is sold in when they all speak the same language. A track
+// sold worldwide gives no hint.
+func Guess(artist, title string, markets []string) string {
+	if lang := Detect(artist + " " + title); lang != Unknown {
+		return lang
+	}
+
+	guess := Unknown
+	for _, m := range markets {
+		lang, ok := marketLanguages[m]
+		if !ok {
+			continue
+		}
+		if guess != Unknown && guess != lang {
+			return Unknown
+		}
+		guess = lang
+	}
+	return guess
+}
+
+// marketLanguages map Spotify markets (ISO 3166-1 alpha-2 countries) to
+// their main language. Countries without one main language are left out.
+var marketLanguages = map[string]string{
+	"US": "en", "GB": "en", "IE": "en", "AU": "en", "NZ": "en",
+	"ES": "es", "MX": "es", "AR": "es", "CO": "es", "CL": "es", "PE": "es",
+	"VE": "es", "EC": "es", "UY": "es", "PY": "es", "BO": "es", "CR": "es",
+	"PA": "es", "DO": "es", "GT": "es", "HN": "es", "SV": "es", "NI": "es",
+	"BR": "pt", "PT": "pt",
+	"FR": "fr",
+	"DE": "de", "AT": "de",
+	"IT": "it",
+	"NL": "nl",
+	"SE": "sv",
+	"KR": "ko",
+	"JP": "ja",
+	"TW": "zh", "HK": "zh",
+	"RU": "ru",
+	"GR": "el",
+	"IL": "he",
+	"TH": "th",
+}
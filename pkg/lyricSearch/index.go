@@ -0,0 +1,387 @@
+// Package lyricSearch is a full-text index of lyrics: an in-memory
+// inverted index from words to the songs using them, saved to a file
+// so that it survives restarts.
+package lyricSearch
+
+import (
+	"encoding/gob"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// Doc is one indexed song.
+type Doc struct {
+	Artist, Title string
+	Lyrics        string
+}
+
+// Hit is a song matching a query.
+type Hit struct {
+	Artist  string     `json:"artist"`
+	Title   string     `json:"title"`
+	URI     string     `json:"uri,omitempty"` // Spotify track URI, if known
+	Score   float64    `json:"score"`
+	Line    int        `json:"line"` // index of the line the snippet comes from
+	Snippet []Fragment `json:"snippet"`
+}
+
+// Fragment is a piece of a snippet; Match marks the words of the query.
+type Fragment struct {
+	Text  string `json:"text"`
+	Match bool   `json:"match,omitempty"`
+}
+
+// Index maps words to the songs they appear in. It is safe for
+// concurrent use.
+type Index struct {
+	path string
+
+	mutex    sync.RWMutex
+	docs     []Doc
+	byKey    map[string]int         // artist and title -> position in docs
+	postings map[string]map[int]int // word -> position in docs -> occurrences
+	uris     map[string]string      // artist and title -> track URI
+	dirty    bool
+}
+
+// saved is what Save writes; the postings are rebuilt on Open.
+type saved struct {
+	Docs []Doc
+	URIs map[string]string
+}
+
+// Open loads the index saved at path, or starts an empty one if there is
+// no such file. An empty path keeps the index in memory only.
+func Open(path string) (*Index, error) {
+	idx := &Index{
+		path:     path,
+		byKey:    make(map[string]int),
+		postings: make(map[string]map[int]int),
+		uris:     make(map[string]string),
+	}
+	if path == "" {
+		return idx, nil
+	}
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return idx, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var s saved
+	if err := gob.NewDecoder(f).Decode(&s); err != nil {
+		return nil, err
+	}
+	for _, d := range s.Docs {
+		idx.add(d)
+	}
+	for k, uri := range s.URIs {
+		idx.uris[k] = uri
+	}
+	idx.dirty = false
+	return idx, nil
+}
+
+// Save writes the index to its file if it changed since it was last
+// saved or opened. URIs of songs that were never indexed, such as
+// those without lyrics, are dropped first.
+func (idx *Index) Save() error {
+	idx.mutex.Lock()
+	defer idx.mutex.Unlock()
+	if idx.path == "" || !idx.dirty {
+		return nil
+	}
+
+	for k := range idx.uris {
+		if _, ok := idx.byKey[k]; !ok {
+			delete(idx.uris, k)
+		}
+	}
+
+	// write aside then rename, so a crash never leaves half an index
+	tmp := idx.path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	err = gob.NewEncoder(f).Encode(saved{idx.docs, idx.uris})
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, idx.path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	idx.dirty = false
+	return nil
+}
+
+// Len is the number of indexed songs.
+func (idx *Index) Len() int {
+	idx.mutex.RLock()
+	defer idx.mutex.RUnlock()
+	return len(idx.docs)
+}
+
+// Add indexes a song, replacing its previous lyrics.
+func (idx *Index) Add(d Doc) {
+	idx.mutex.Lock()
+	defer idx.mutex.Unlock()
+	idx.add(d)
+}
+
+// Remove drops a song and its URI from the index.
+func (idx *Index) Remove(artist, title string) {
+	k := key(artist, title)
+	idx.mutex.Lock()
+	defer idx.mutex.Unlock()
+
+	if _, ok := idx.uris[k]; ok {
+		delete(idx.uris, k)
+		idx.dirty = true
+	}
+	id, ok := idx.byKey[k]
+	if !ok {
+		return
+	}
+	idx.unpost(id)
+	delete(idx.byKey, k)
+
+	// move the last song into the gap
+	last := len(idx.docs) - 1
+	if id != last {
+		moved := idx.docs[last]
+		for w, n := range wordCounts(moved) {
+			delete(idx.postings[w], last)
+			idx.postings[w][id] = n
+		}
+		idx.docs[id] = moved
+		idx.byKey[key(moved.Artist, moved.Title)] = id
+	}
+	idx.docs = idx.docs[:last]
+	idx.dirty = true
+}
+
+// Get returns the indexed song of an artist and title.
+func (idx *Index) Get(artist, title string) (Doc, bool) {
+	idx.mutex.RLock()
+	defer idx.mutex.RUnlock()
+	id, ok := idx.byKey[key(artist, title)]
+	if !ok {
+		return Doc{}, false
+	}
+	return idx.docs[id], true
+}
+
+// SetURI remembers the Spotify URI of a song, indexed or not yet.
+func (idx *Index) SetURI(artist, title, uri string) {
+	k := key(artist, title)
+	idx.mutex.Lock()
+	defer idx.mutex.Unlock()
+	if idx.uris[k] != uri {
+		idx.uris[k] = uri
+		idx.dirty = true
+	}
+}
+
+func (idx *Index) add(d Doc) {
+	k := key(d.Artist, d.Title)
+	id, ok := idx.byKey[k]
+	if ok {
+		if idx.docs[id] == d {
+			return
+		}
+		idx.unpost(id)
+		idx.docs[id] = d
+	} else {
+		id = len(idx.docs)
+		idx.docs = append(idx.docs, d)
+		idx.byKey[k] = id
+	}
+
+	for w, n := range wordCounts(d) {
+		if idx.postings[w] == nil {
+			idx.postings[w] = make(map[int]int)
+		}
+		idx.postings[w][id] = n
+	}
+	idx.dirty = true
+}
+
+// unpost removes the song at id from the postings of its words.
+func (idx *Index) unpost(id int) {
+	for w := range wordCounts(idx.docs[id]) {
+		delete(idx.postings[w], id)
+		if len(idx.postings[w]) == 0 {
+			delete(idx.postings, w)
+		}
+	}
+}
+
+// Search returns up to limit songs using every word of the query, best
+// first. Words weigh more the fewer songs use them, and songs holding
+// the query as a phrase come first.
+func (idx *Index) Search(query string, limit int) []Hit {
+	terms := unique(words(query))
+	if len(terms) == 0 {
+		return nil
+	}
+	phrase := strings.Join(words(query), " ")
+
+	idx.mutex.RLock()
+	defer idx.mutex.RUnlock()
+
+	// start from the rarest word, keeping the songs that have them all
+	sort.Slice(terms, func(i, j int) bool { return len(idx.postings[terms[i]]) < len(idx.postings[terms[j]]) })
+	scores := make(map[int]float64)
+	for id := range idx.postings[terms[0]] {
+		scores[id] = 0
+	}
+	for _, t := range terms {
+		docs := idx.postings[t]
+		idf := math.Log(1 + float64(len(idx.docs))/float64(len(docs)+1))
+		for id := range scores {
+			n, ok := docs[id]
+			if !ok {
+				delete(scores, id)
+				continue
+			}
+			scores[id] += (1 + math.Log(float64(n))) * idf
+		}
+	}
+
+	hits := make([]Hit, 0, len(scores))
+	for id, score := range scores {
+		d := idx.docs[id]
+		line, snippet, isPhrase := snippetOf(d.Lyrics, terms, phrase)
+		if isPhrase {
+			score *= 2
+		}
+		hits = append(hits, Hit{
+			Artist:  d.Artist,
+			Title:   d.Title,
+			URI:     idx.uris[key(d.Artist, d.Title)],
+			Score:   score,
+			Line:    line,
+			Snippet: snippet,
+		})
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].Title < hits[j].Title
+	})
+	if len(hits) > limit {
+		hits = hits[:limit]
+	}
+	return hits
+}
+
+// snippetOf picks the line holding the phrase, or else the most words
+// of the query, and highlights those words.
+func snippetOf(lyrics string, terms []string, phrase string) (line int, snippet []Fragment, isPhrase bool) {
+	want := make(map[string]bool, len(terms))
+	for _, t := range terms {
+		want[t] = true
+	}
+
+	lines := strings.Split(lyrics, "\n")
+	best := -1
+	for i, l := range lines {
+		lw := words(l)
+		if strings.Contains(" "+strings.Join(lw, " ")+" ", " "+phrase+" ") {
+			line, isPhrase = i, true
+			break
+		}
+		n := 0
+		for _, w := range unique(lw) {
+			if want[w] {
+				n++
+			}
+		}
+		if n > best {
+			line, best = i, n
+		}
+	}
+	return line, highlight(lines[line], want), isPhrase
+}
+
+// highlight splits text into words and what separates them, marking
+// the wanted words.
+func highlight(text string, want map[string]bool) []Fragment {
+	var frags []Fragment
+	runes := []rune(strings.TrimSpace(text))
+	for start := 0; start < len(runes); {
+		end := start
+		inWord := isWordRune(runes[start])
+		for end < len(runes) && isWordRune(runes[end]) == inWord {
+			end++
+		}
+		piece := string(runes[start:end])
+		match := inWord && want[normalize(piece)]
+		if n := len(frags); n > 0 && !frags[n-1].Match && !match {
+			frags[n-1].Text += piece
+		} else {
+			frags = append(frags, Fragment{piece, match})
+		}
+		start = end
+	}
+	return frags
+}
+
+func key(artist, title string) string {
+	return strings.ToLower(artist) + "\x00" + strings.ToLower(title)
+}
+
+// wordCounts counts the words of a song; its title and artist count too.
+func wordCounts(d Doc) map[string]int {
+	counts := make(map[string]int)
+	for _, w := range words(d.Artist + "\n" + d.Title + "\n" + d.Lyrics) {
+		counts[w]++
+	}
+	return counts
+}
+
+// words splits text into lowercase words. Apostrophes are dropped
+// rather than splitting words, so "don't" and "dont" match.
+func words(text string) []string {
+	var ws []string
+	for _, f := range strings.FieldsFunc(text, func(r rune) bool { return !isWordRune(r) }) {
+		if w := normalize(f); w != "" {
+			ws = append(ws, w)
+		}
+	}
+	return ws
+}
+
+func normalize(word string) string {
+	word = strings.NewReplacer("'", "", "’", "").Replace(word)
+	return strings.ToLower(word)
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsNumber(r) || r == '\'' || r == '’'
+}
+
+func unique(ws []string) []string {
+	seen := make(map[string]bool, len(ws))
+	var out []string
+	for _, w := range ws {
+		if !seen[w] {
+			seen[w] = true
+			out = append(out, w)
+		}
+	}
+	return out
+}
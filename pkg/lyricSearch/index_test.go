@@ -0,0 +1,157 @@
+package lyricSearch
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestWords(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"", nil},
+		{"Hello, World!", []string{"hello", "world"}},
+		{"Don't stop  me now", []string{"dont", "stop", "me", "now"}},
+		{"rock’n’roll 99 luftballons", []string{"rocknroll", "99", "luftballons"}},
+		{"Corazón-Partío", []string{"corazón", "partío"}},
+		{"사랑해 너를", []string{"사랑해", "너를"}},
+		{"' '' ’", nil},
+	}
+	for _, tt := range tests {
+		if got := words(tt.text); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("words(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func titles(hits []Hit) []string {
+	var ts []string
+	for _, h := range hits {
+		ts = append(ts, h.Title)
+	}
+	return ts
+}
+
+func TestAddRemove(t *testing.T) {
+	idx, _ := Open("")
+	idx.Add(Doc{"A", "One", "sun and rain"})
+	idx.Add(Doc{"A", "Two", "rain rain rain"})
+	idx.Add(Doc{"B", "Three", "sun sun moon"})
+	idx.SetURI("A", "One", "spotify:track:1")
+
+	tests := []struct {
+		name  string
+		do    func()
+		query string
+		want  []string
+	}{
+		{"rarer words count more", func() {}, "rain", []string{"Two", "One"}},
+		{"every word", func() {}, "sun rain", []string{"One"}},
+		{"artist and title", func() {}, "b three", []string{"Three"}},
+		{"replace", func() { idx.Add(Doc{"a", "one", "snow"}) }, "rain", []string{"Two"}},
+		{"remove the first", func() { idx.Remove("A", "One") }, "snow", nil},
+		{"the last moves into the gap", func() {}, "moon", []string{"Three"}},
+		{"remove unknown", func() { idx.Remove("C", "Four") }, "rain", []string{"Two"}},
+		{"remove the last", func() { idx.Remove("b", "three") }, "sun", nil},
+	}
+	for _, tt := range tests {
+		tt.do()
+		if got := titles(idx.Search(tt.query, 10)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	if idx.Len() != 1 || len(idx.postings["sun"]) != 0 || len(idx.uris) != 0 {
+		t.Errorf("left %d songs, postings %v and URIs %v", idx.Len(), idx.postings, idx.uris)
+	}
+	if _, ok := idx.Get("A", "Two"); !ok {
+		t.Errorf("lost A - Two")
+	}
+}
+
+func TestSnippet(t *testing.T) {
+	idx, _ := Open("")
+	idx.Add(Doc{"A", "Song", "hold me close\nhold me tight, don't let go\nclose and tight"})
+
+	tests := []struct {
+		query string
+		line  int
+		want  []Fragment
+	}{
+		{"dont let", 1, []Fragment{{"hold me tight, ", false}, {"don't", true}, {" ", false}, {"let", true}, {" go", false}}},
+		{"tight close", 2, []Fragment{{"close", true}, {" and ", false}, {"tight", true}}},
+		{"HOLD", 0, []Fragment{{"hold", true}, {" me close", false}}},
+	}
+	for _, tt := range tests {
+		hits := idx.Search(tt.query, 1)
+		if len(hits) != 1 {
+			t.Fatalf("%q: got %d hits", tt.query, len(hits))
+		}
+		if hits[0].Line != tt.line || !reflect.DeepEqual(hits[0].Snippet, tt.want) {
+			t.Errorf("%q: got line %d %+v, want line %d %+v", tt.query, hits[0].Line, hits[0].Snippet, tt.line, tt.want)
+		}
+	}
+
+	// the phrase wins over a line holding more of the words
+	idx.Add(Doc{"B", "Other", "go let go\nlet it go"})
+	if hits := idx.Search("let it", 1); len(hits) != 1 || hits[0].Line != 1 {
+		t.Errorf("phrase: got %+v", hits)
+	}
+}
+
+func TestSaveOpen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lyrics.idx")
+	idx, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx.Add(Doc{"A", "One", "sun and rain"})
+	idx.Add(Doc{"A", "Two", "snow"})
+	idx.SetURI("A", "One", "spotify:track:1")
+	idx.SetURI("C", "No Lyrics", "spotify:track:3")
+	if err := idx.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// nothing changed, so nothing is written
+	os.Remove(path)
+	if err := idx.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("unchanged index saved: %v", err)
+	}
+	idx.Add(Doc{"A", "Two", "hail"})
+	if err := idx.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Len() != 2 || loaded.dirty {
+		t.Errorf("loaded %d songs, dirty %v", loaded.Len(), loaded.dirty)
+	}
+	hits := loaded.Search("rain", 10)
+	if len(hits) != 1 || hits[0].URI != "spotify:track:1" {
+		t.Errorf("got %+v", hits)
+	}
+	if got := titles(loaded.Search("hail", 10)); !reflect.DeepEqual(got, []string{"Two"}) {
+		t.Errorf("got %q for the changed song", got)
+	}
+	if !reflect.DeepEqual(loaded.uris, map[string]string{key("A", "One"): "spotify:track:1"}) {
+		t.Errorf("got URIs %v, want the one of an indexed song", loaded.uris)
+	}
+
+	if _, err := Open(filepath.Join(t.TempDir(), "missing.idx")); err != nil {
+		t.Errorf("missing file: %v", err)
+	}
+	os.WriteFile(path, []byte("not gob"), 0o600)
+	if _, err := Open(path); err == nil {
+		t.Errorf("corrupt file opened")
+	}
+}
@@ -0,0 +1,133 @@
+package lyricSheet
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+
+	"github.com/jung-kurt/gofpdf"
+)
+
+const (
+	margin  = 15.0 // mm
+	gutter  = 8.0  // mm between columns
+	artSize = 30.0 // mm
+)
+
+// PDF writes the sheet as an A4 PDF: a header with the cover, title,
+// artist and album, then the stanzas flowing down the columns. A stanza
+// that fits in a column is never split across two.
+func PDF(w io.Writer, s *Sheet, o Options) error {
+	if o.FontSize <= 0 {
+		o.FontSize = 12
+	}
+	if o.Columns <= 0 {
+		o.Columns = 1
+	}
+
+	pdf := gofpdf.New("P", "mm", "A4", "")
+	pdf.SetMargins(margin, margin, margin)
+	pdf.SetAutoPageBreak(false, margin)
+	pdf.SetTitle(s.Title, true)
+	pdf.SetAuthor(s.Artist, true)
+	// the core fonts only know cp1252
+	tr := pdf.UnicodeTranslatorFromDescriptor("")
+	pdf.AddPage()
+
+	pageW, pageH := pdf.GetPageSize()
+	bottom := pageH - margin
+
+	// header
+	textW := pageW - 2*margin
+	img := artImage(pdf, s.Art)
+	if img != "" {
+		pdf.ImageOptions(img, pageW-margin-artSize, margin, artSize, artSize, false, gofpdf.ImageOptions{}, 0, "")
+		textW -= artSize + gutter
+	}
+	pdf.SetFont("Helvetica", "B", float64(o.FontSize)*2)
+	pdf.MultiCell(textW, float64(o.FontSize)*0.8, tr(s.Title), "", "L", false)
+	pdf.SetFont("Helvetica", "", float64(o.FontSize)*1.2)
+	by := s.Artist
+	if s.Album != "" {
+		by += " · " + s.Album
+	}
+	pdf.MultiCell(textW, float64(o.FontSize)*0.6, tr(by), "", "L", false)
+	top := pdf.GetY() + gutter
+	if img != "" && top < margin+artSize+gutter {
+		top = margin + artSize + gutter
+	}
+
+	// body
+	colW := (pageW - 2*margin - float64(o.Columns-1)*gutter) / float64(o.Columns)
+	lineH := float64(o.FontSize) * 0.45 // points to mm, with some leading
+	col, y := 0, top
+	nextColumn := func() {
+		col++
+		if col == o.Columns {
+			pdf.AddPage()
+			col, top = 0, margin
+		}
+		y = top
+	}
+
+	for _, st := range Stanzas(s.Lyrics) {
+		pdf.SetFont("Helvetica", "", float64(o.FontSize))
+		var lines []string
+		for _, l := range st.Lines {
+			// translated text is no longer UTF-8, so split it as bytes
+			for _, part := range pdf.SplitLines([]byte(tr(l)), colW) {
+				lines = append(lines, string(part))
+			}
+		}
+		height := float64(len(lines)) * lineH
+		if st.Label != "" {
+			height += lineH
+		}
+		if y+height > bottom && height <= bottom-top && y > top {
+			nextColumn()
+		}
+
+		x := margin + float64(col)*(colW+gutter)
+		if st.Label != "" {
+			pdf.SetFont("Helvetica", "B", float64(o.FontSize))
+			pdf.Text(x, y+lineH*0.8, tr(st.Label))
+			y += lineH
+		}
+		style := ""
+		if st.Chorus {
+			style = "I"
+		}
+		pdf.SetFont("Helvetica", style, float64(o.FontSize))
+		for _, l := range lines {
+			if y+lineH > bottom {
+				nextColumn()
+				x = margin + float64(col)*(colW+gutter)
+			}
+			pdf.Text(x, y+lineH*0.8, l)
+			y += lineH
+		}
+		y += lineH // blank line between stanzas
+	}
+
+	return pdf.Output(w)
+}
+
+// artImage registers the cover and returns its name, or "" if there is
+// none or it is neither a JPEG nor a PNG.
+func artImage(pdf *gofpdf.Fpdf, art []byte) string {
+	var imageType string
+	switch http.DetectContentType(art) {
+	case "image/jpeg":
+		imageType = "JPG"
+	case "image/png":
+		imageType = "PNG"
+	default:
+		return ""
+	}
+	pdf.RegisterImageOptionsReader("art", gofpdf.ImageOptions{ImageType: imageType}, bytes.NewReader(art))
+	if pdf.Err() {
+		pdf.ClearError()
+		return ""
+	}
+	return "art"
+}
@@ -0,0 +1,37 @@
+// Package lyricSheet lays out the lyrics of one song as a printable
+// sheet: the song split into stanzas, rendered as HTML by the app's
+// templates or as a PDF by this package.
+package lyricSheet
+
+import "spotify-live-lyricist/pkg/lyricText"
+
+// Sheet is one song to print.
+type Sheet struct {
+	Title, Artist, Album string
+	Art                  []byte // JPEG or PNG cover, optional
+	Lyrics               string
+}
+
+// Options tune the layout of a sheet.
+type Options struct {
+	FontSize int // in points
+	Columns  int
+}
+
+// Stanza is a block of lines separated from the next by a blank line.
+// Label is the section heading the lyrics gave it, such as "Chorus".
+type Stanza struct {
+	Label  string
+	Chorus bool
+	Lines  []string
+}
+
+// Stanzas splits lyrics on blank lines and section headings.
+func Stanzas(lyrics string) []Stanza {
+	sections := lyricText.Sections(lyrics)
+	stanzas := make([]Stanza, len(sections))
+	for i, s := range sections {
+		stanzas[i] = Stanza{Label: s.Label, Chorus: s.Chorus(), Lines: s.Lines}
+	}
+	return stanzas
+}
@@ -0,0 +1,135 @@
+// Package lyricSync reads synced lyrics in the LRC format, where each
+// line starts with the time it is sung at, such as "[01:02.50]".
+package lyricSync
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Line is one line of lyrics; Timed tells whether Start is known.
+// Starts has every time the line is sung, Start being the first.
+type Line struct {
+	Text   string
+	Start  time.Duration
+	Starts []time.Duration
+	Timed  bool
+}
+
+// Lyrics are the lines of a song, Synced if any of them is timed.
+type Lyrics struct {
+	Lines  []Line
+	Synced bool
+}
+
+var (
+	timeTag = regexp.MustCompile(`^\[(\d+):(\d{1,2})(?:[.:](\d{1,3}))?\]`)
+	idTag   = regexp.MustCompile(`(?i)^\[(ar|ti|al|au|by|re|ve|length|offset):(.*)\]\s*$`)
+)
+
+// Parse reads lyrics with or without time tags. ID tags such as
+// [ar:Artist] are dropped, apart from [offset:ms], which moves every
+// line earlier by ms. A line with several time tags is sung at each of
+// them, in order.
+func Parse(text string) Lyrics {
+	var l Lyrics
+	var offset time.Duration
+
+	for _, raw := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n") {
+		line := Line{Text: raw}
+		if m := timeTag.FindStringSubmatch(raw); m != nil {
+			line.Timed = true
+			rest := raw
+			for m != nil {
+				line.Starts = append(line.Starts, tagTime(m))
+				rest = rest[len(m[0]):]
+				m = timeTag.FindStringSubmatch(rest)
+			}
+			sort.Slice(line.Starts, func(i, j int) bool { return line.Starts[i] < line.Starts[j] })
+			line.Text = strings.TrimSpace(rest)
+			l.Synced = true
+		} else if m := idTag.FindStringSubmatch(raw); m != nil {
+			if strings.EqualFold(m[1], "offset") {
+				if ms, err := strconv.Atoi(strings.TrimSpace(m[2])); err == nil {
+					offset = time.Duration(ms) * time.Millisecond
+				}
+			}
+			continue
+		}
+		l.Lines = append(l.Lines, line)
+	}
+
+	for i := range l.Lines {
+		line := &l.Lines[i]
+		for j := range line.Starts {
+			line.Starts[j] -= offset
+			if line.Starts[j] < 0 {
+				line.Starts[j] = 0
+			}
+		}
+		if line.Timed {
+			line.Start = line.Starts[0]
+		}
+	}
+	return l
+}
+
+// Plain returns the lyrics without any tag, one line per Line.
+func Plain(text string) string {
+	l := Parse(text)
+	lines := make([]string, len(l.Lines))
+	for i, line := range l.Lines {
+		lines[i] = line.Text
+	}
+	return strings.Join(lines, "\n")
+}
+
+// LRC writes the lyrics back with a time tag for every time a line is
+// sung, after the offset, and without ID tags.
+func (l Lyrics) LRC() string {
+	var sb strings.Builder
+	for i, line := range l.Lines {
+		if i > 0 {
+			sb.WriteByte('\n')
+		}
+		for _, start := range line.Starts {
+			cs := start.Milliseconds() / 10
+			fmt.Fprintf(&sb, "[%02d:%02d.%02d]", cs/6000, cs/100%60, cs%100)
+		}
+		sb.WriteString(line.Text)
+	}
+	return sb.String()
+}
+
+// At returns the start of line i, if it is known.
+func (l Lyrics) At(i int) (time.Duration, bool) {
+	return l.Repeat(i, 0)
+}
+
+// Repeat returns the start of repeat n of line i, the first time it is
+// sung being repeat 0, if it is known.
+func (l Lyrics) Repeat(i, n int) (time.Duration, bool) {
+	if i < 0 || i >= len(l.Lines) || n < 0 || n >= len(l.Lines[i].Starts) {
+		return 0, false
+	}
+	return l.Lines[i].Starts[n], true
+}
+
+func tagTime(m []string) time.Duration {
+	min, _ := strconv.Atoi(m[1])
+	sec, _ := strconv.Atoi(m[2])
+	d := time.Duration(min)*time.Minute + time.Duration(sec)*time.Second
+	if frac := m[3]; frac != "" {
+		// .5, .50 and .500 are all half a second
+		n, _ := strconv.Atoi(frac)
+		for i := len(frac); i < 3; i++ {
+			n *= 10
+		}
+		d += time.Duration(n) * time.Millisecond
+	}
+	return d
+}
@@ -0,0 +1,107 @@
+package lyricSync
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func ms(n int) time.Duration { return time.Duration(n) * time.Millisecond }
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want Lyrics
+	}{
+		{"empty", "", Lyrics{Lines: []Line{{Text: ""}}}},
+		{"plain", "one\ntwo", Lyrics{Lines: []Line{{Text: "one"}, {Text: "two"}}}},
+		{"timed", "[00:01.00]one\n[01:02.5]two", Lyrics{Synced: true, Lines: []Line{
+			{Text: "one", Start: ms(1000), Starts: []time.Duration{ms(1000)}, Timed: true},
+			{Text: "two", Start: ms(62500), Starts: []time.Duration{ms(62500)}, Timed: true},
+		}}},
+		{"fractions", "[00:01.5]a\n[00:01.50]b\n[00:01.500]c\n[00:01:05]d\n[00:02]e", Lyrics{Synced: true, Lines: []Line{
+			{Text: "a", Start: ms(1500), Starts: []time.Duration{ms(1500)}, Timed: true},
+			{Text: "b", Start: ms(1500), Starts: []time.Duration{ms(1500)}, Timed: true},
+			{Text: "c", Start: ms(1500), Starts: []time.Duration{ms(1500)}, Timed: true},
+			{Text: "d", Start: ms(1050), Starts: []time.Duration{ms(1050)}, Timed: true},
+			{Text: "e", Start: ms(2000), Starts: []time.Duration{ms(2000)}, Timed: true},
+		}}},
+		{"repeats in order", "[01:00.00][00:10.00] chorus ", Lyrics{Synced: true, Lines: []Line{
+			{Text: "chorus", Start: ms(10000), Starts: []time.Duration{ms(10000), ms(60000)}, Timed: true},
+		}}},
+		{"mixed", "[00:01.00]timed\nuntimed", Lyrics{Synced: true, Lines: []Line{
+			{Text: "timed", Start: ms(1000), Starts: []time.Duration{ms(1000)}, Timed: true},
+			{Text: "untimed"},
+		}}},
+		{"ID tags and offset", "[ar:Artist]\r\n[ti:Title]\r\n[offset:1500]\r\n[00:01.00]early\r\n[00:03.00]late", Lyrics{Synced: true, Lines: []Line{
+			{Text: "early", Start: 0, Starts: []time.Duration{0}, Timed: true},
+			{Text: "late", Start: ms(1500), Starts: []time.Duration{ms(1500)}, Timed: true},
+		}}},
+		{"negative offset", "[offset:-500]\n[00:01.00]one", Lyrics{Synced: true, Lines: []Line{
+			{Text: "one", Start: ms(1500), Starts: []time.Duration{ms(1500)}, Timed: true},
+		}}},
+		{"not a tag", "[Chorus]\n[xx:01.00]one", Lyrics{Lines: []Line{{Text: "[Chorus]"}, {Text: "[xx:01.00]one"}}}},
+	}
+	for _, tt := range tests {
+		if got := Parse(tt.text); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %+v\nwant %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPlain(t *testing.T) {
+	tests := []struct {
+		text, want string
+	}{
+		{"", ""},
+		{"one\ntwo", "one\ntwo"},
+		{"[ar:Artist]\n[offset:500]\n[00:01.00]first verse\n[00:10.5][01:00.00]chorus lala\nuntimed", "first verse\nchorus lala\nuntimed"},
+		{"[Verse 1]\nla", "[Verse 1]\nla"},
+	}
+	for _, tt := range tests {
+		if got := Plain(tt.text); got != tt.want {
+			t.Errorf("Plain(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestRepeat(t *testing.T) {
+	l := Parse("[00:10.00][01:00.00]chorus\nuntimed")
+	tests := []struct {
+		line, n int
+		want    time.Duration
+		ok      bool
+	}{
+		{0, 0, ms(10000), true},
+		{0, 1, ms(60000), true},
+		{0, 2, 0, false},
+		{0, -1, 0, false},
+		{1, 0, 0, false},
+		{2, 0, 0, false},
+		{-1, 0, 0, false},
+	}
+	for _, tt := range tests {
+		if got, ok := l.Repeat(tt.line, tt.n); got != tt.want || ok != tt.ok {
+			t.Errorf("Repeat(%d, %d) = %s, %v, want %s, %v", tt.line, tt.n, got, ok, tt.want, tt.ok)
+		}
+	}
+	if got, ok := l.At(0); got != ms(10000) || !ok {
+		t.Errorf("At(0) = %s, %v", got, ok)
+	}
+}
+
+func TestLRC(t *testing.T) {
+	tests := []struct {
+		text, want string
+	}{
+		{"one\ntwo", "one\ntwo"},
+		{"[ar:Artist]\n[offset:500]\n[00:01.00]first\n[00:10.5][01:00.00]chorus\nuntimed", "[00:00.50]first\n[00:10.00][00:59.50]chorus\nuntimed"},
+		{"[12:03.456]late", "[12:03.45]late"},
+	}
+	for _, tt := range tests {
+		if got := Parse(tt.text).LRC(); got != tt.want {
+			t.Errorf("LRC of %q = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
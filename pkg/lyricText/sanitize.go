@@ -0,0 +1,139 @@
+// Package lyricText cleans up the lyrics scraped from providers and
+// splits them into the sections of a song.
+package lyricText
+
+import (
+	"html"
+	"regexp"
+	"strings"
+	"unicode"
+
+	nethtml "golang.org/x/net/html"
+	"golang.org/x/text/unicode/norm"
+)
+
+var (
+	// a tag such as <br>, </p> or <!-- -->; "<3" is not one
+	tag = regexp.MustCompile(`<[a-zA-Z/!]`)
+	// a newline after a tag that already breaks the line
+	tagNewline = regexp.MustCompile(`(?i)(<br\s*/?>|</(?:p|div|li|h[1-6]|tr)>)[ \t]*\n`)
+
+	// Genius glues "Embed", after a count, to the last line
+	embed = regexp.MustCompile(`(\S)\d*Embed$`)
+
+	// boilerplate are whole lines providers add to lyrics
+	boilerplate = []*regexp.Regexp{
+		regexp.MustCompile(`(?i)^\**\s*this lyrics is not for commercial use\s*\**$`),
+		regexp.MustCompile(`^\(\d{6,}\)$`), // Musixmatch tracking ID
+		regexp.MustCompile(`(?i)^lyrics (?:powered|provided|licensed|courtesy)\b.*\bby\b`),
+		regexp.MustCompile(`(?i)^you might also like$`),
+		regexp.MustCompile(`(?i)^\d*embed$`),
+		regexp.MustCompile(`(?i)^(?:see .* live\s*)?get tickets as low as\b`),
+		regexp.MustCompile(`(?i)^\d+ contributors?.*lyrics$`),
+	}
+
+	// elements that end a line of text
+	breaks = map[string]bool{"br": true, "p": true, "div": true, "li": true, "h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true, "tr": true}
+	// elements whose content is not text
+	skipped = map[string]bool{"script": true, "style": true, "head": true, "title": true, "iframe": true, "noscript": true}
+)
+
+// Sanitize turns scraped lyrics into plain text: markup stripped, known
+// provider boilerplate removed, Unicode in NFC with one kind of space and
+// line ending, and no more than one blank line in a row. LRC time tags
+// are kept.
+func Sanitize(raw string) string {
+	text := norm.NFC.String(raw)
+	text = strings.NewReplacer("\r\n", "\n", "\r", "\n", "\u2028", "\n", "\u2029", "\n").Replace(text)
+	if tag.MatchString(text) {
+		text = stripMarkup(tagNewline.ReplaceAllString(text, "$1"))
+	} else {
+		text = html.UnescapeString(text)
+	}
+
+	var lines []string
+	blank := true // drops leading blank lines
+	for _, line := range strings.Split(cleanRunes(text), "\n") {
+		line = strings.Join(strings.Fields(line), " ")
+		line = embed.ReplaceAllString(line, "$1")
+		if isBoilerplate(line) {
+			continue
+		}
+		if line == "" {
+			if !blank {
+				lines = append(lines, "")
+			}
+			blank = true
+			continue
+		}
+		lines = append(lines, line)
+		blank = false
+	}
+	if n := len(lines); n > 0 && lines[n-1] == "" {
+		lines = lines[:n-1]
+	}
+	return strings.Join(lines, "\n")
+}
+
+// stripMarkup keeps the text of an HTML fragment, with a line break
+// for every <br> and after every block.
+func stripMarkup(fragment string) string {
+	var b strings.Builder
+	z := nethtml.NewTokenizer(strings.NewReader(fragment))
+	skip := 0
+	for {
+		switch z.Next() {
+		case nethtml.ErrorToken:
+			return b.String()
+		case nethtml.TextToken:
+			if skip == 0 {
+				b.Write(z.Text())
+			}
+		case nethtml.StartTagToken, nethtml.SelfClosingTagToken:
+			name, _ := z.TagName()
+			switch {
+			case skipped[string(name)]:
+				skip++
+			case string(name) == "br":
+				b.WriteByte('\n')
+			}
+		case nethtml.EndTagToken:
+			name, _ := z.TagName()
+			switch {
+			case skipped[string(name)]:
+				if skip > 0 {
+					skip--
+				}
+			case breaks[string(name)]:
+				b.WriteByte('\n')
+			}
+		}
+	}
+}
+
+// cleanRunes drops control and zero-width characters and turns every
+// other space into a plain one.
+func cleanRunes(text string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r == '\n':
+			return r
+		case r == '\u200b' || r == '\u200c' || r == '\u200d' || r == '\ufeff':
+			return -1
+		case unicode.IsSpace(r):
+			return ' '
+		case unicode.IsControl(r):
+			return -1
+		}
+		return r
+	}, text)
+}
+
+func isBoilerplate(line string) bool {
+	for _, re := range boilerplate {
+		if re.MatchString(line) {
+			return true
+		}
+	}
+	return false
+}
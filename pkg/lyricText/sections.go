@@ -0,0 +1,125 @@
+package lyricText
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Section is a part of a song: a block of lines after a heading such as
+// "[Chorus]", or separated from the next by a blank line. Start and End
+// are the range of Lines among the lines of the lyrics.
+//
+// A section whose lines were sung before, or a heading alone naming an
+// earlier section (a bare "[Chorus]"), repeats it: First is the index of
+// that section among the song's sections, and of the section itself
+// otherwise. Total counts the times the block is sung in the whole song.
+type Section struct {
+	Kind  string   `json:"kind,omitempty"`  // "verse", "chorus", ... or empty without a heading
+	Label string   `json:"label,omitempty"` // the heading as written, such as "Verse 2: Artist"
+	Lines []string `json:"lines"`
+	Start int      `json:"start"`
+	End   int      `json:"end"`
+	Times int      `json:"times"` // in a row where written, such as 2 for "[Chorus x2]"
+	First int      `json:"first"`
+	Total int      `json:"total"`
+}
+
+// Chorus reports whether the section's heading names it a chorus, hook
+// or refrain. A block repeated without such a heading isn't one; Total
+// tells how often any section is sung.
+func (s Section) Chorus() bool {
+	return s.Kind == "chorus"
+}
+
+// "[Chorus]", "[Verse 2: Artist]", "Chorus x2:", "(Bridge)", "{Hook}"
+// or "*Outro*" on a line of its own, but not "Intro to the night"
+var heading = regexp.MustCompile(`(?i)^[\[({*]?\s*((?:pre|post)?-?\s?chorus|verse|bridge|intro|outro|hook|refrain|interlude|breakdown|instrumental)(\s*\d*(?:\s*[x×]\s*\d+)?(?:\s*[:\-–][^\])}*]*)?)\s*[\])}*]?\s*:?$`)
+
+// "x2" or "×3" in a heading
+var times = regexp.MustCompile(`(?i)[x×]\s*(\d+)`)
+
+// Sections splits lyrics on blank lines and headings, which are
+// recognized in all the forms providers write them in, and finds the
+// sections that repeat others.
+func Sections(lyrics string) []Section {
+	var sections []Section
+	cur := Section{Times: 1}
+	flush := func(next int) {
+		if len(cur.Lines) > 0 || cur.Label != "" {
+			cur.End = cur.Start + len(cur.Lines)
+			sections = append(sections, cur)
+		}
+		cur = Section{Start: next, Times: 1}
+	}
+
+	lyrics = strings.ReplaceAll(lyrics, "\r\n", "\n")
+	for i, line := range strings.Split(lyrics, "\n") {
+		line = strings.TrimSpace(line)
+		switch m := heading.FindStringSubmatch(line); {
+		case line == "":
+			flush(i + 1)
+		case m != nil:
+			flush(i + 1)
+			cur.Kind = kindOf(m[1])
+			cur.Label = strings.TrimSpace(strings.Trim(line, "[](){}*:- "))
+			if t := times.FindStringSubmatch(m[2]); t != nil {
+				if n, err := strconv.Atoi(t[1]); err == nil && n > 1 {
+					cur.Times = n
+				}
+			}
+		default:
+			cur.Lines = append(cur.Lines, line)
+		}
+	}
+	flush(0)
+
+	findRepeats(sections)
+	return sections
+}
+
+// findRepeats sets First and Total of every section.
+func findRepeats(sections []Section) {
+	byLines := make(map[string]int)
+	byLabel := make(map[string]int)
+	for i := range sections {
+		s := &sections[i]
+		s.First = i
+		label := strings.ToLower(strings.TrimSpace(times.ReplaceAllString(s.Label, "")))
+		if len(s.Lines) > 0 {
+			key := strings.ToLower(strings.Join(s.Lines, "\n"))
+			if first, ok := byLines[key]; ok {
+				s.First = first
+			} else {
+				byLines[key] = i
+			}
+			if _, ok := byLabel[label]; !ok && label != "" {
+				byLabel[label] = s.First
+			}
+		} else if first, ok := byLabel[label]; ok {
+			s.First = first
+		}
+	}
+
+	totals := make(map[int]int)
+	for _, s := range sections {
+		totals[s.First] += s.Times
+	}
+	for i := range sections {
+		sections[i].Total = totals[sections[i].First]
+	}
+}
+
+// kindOf names the kind of section a heading word stands for.
+func kindOf(word string) string {
+	word = strings.ToLower(strings.Join(strings.FieldsFunc(word, func(r rune) bool { return r == ' ' || r == '-' }), ""))
+	switch word {
+	case "hook", "refrain":
+		return "chorus"
+	case "prechorus":
+		return "pre-chorus"
+	case "postchorus":
+		return "post-chorus"
+	}
+	return word
+}
@@ -0,0 +1,43 @@
+package lyricText
+
+import "testing"
+
+func TestChorus(t *testing.T) {
+	tests := []struct {
+		name   string
+		lyrics string
+		chorus []bool
+		total  []int
+	}{
+		{
+			name:   "heading",
+			lyrics: "[Verse 1]\nfirst\n\n[Chorus]\nla la\n\n[Verse 2]\nsecond\n\n[Chorus]",
+			chorus: []bool{false, true, false, true},
+			total:  []int{1, 2, 1, 2},
+		},
+		{
+			name:   "hook and refrain",
+			lyrics: "{Hook}\nhey\n\n(Refrain x2)\nho",
+			chorus: []bool{true, true},
+			total:  []int{1, 2},
+		},
+		{
+			name:   "repeated block without heading",
+			lyrics: "first\n\nla la\n\nsecond\n\nla la",
+			chorus: []bool{false, false, false, false},
+			total:  []int{1, 2, 1, 2},
+		},
+	}
+	for _, tt := range tests {
+		sections := Sections(tt.lyrics)
+		if len(sections) != len(tt.chorus) {
+			t.Errorf("%s: got %d sections, want %d", tt.name, len(sections), len(tt.chorus))
+			continue
+		}
+		for i, s := range sections {
+			if s.Chorus() != tt.chorus[i] || s.Total != tt.total[i] {
+				t.Errorf("%s: section %d (%q): got chorus %v, total %d, want %v, %d", tt.name, i, s.Label, s.Chorus(), s.Total, tt.chorus[i], tt.total[i])
+			}
+		}
+	}
+}
@@ -1,7 +1,6 @@
 package lyricTreeSet
 
 import (
-	"fmt"
 	"github.com/emirpasic/gods/lists/singlylinkedlist"
 	"github.com/emirpasic/gods/maps/hashmap"
 )
@@ -17,6 +16,11 @@ type metaLyric struct {
 	artist, title		string
 }
 
+// Entry identifies a lyric stored in the set.
+type Entry struct {
+	Artist, Title		string
+}
+
 // Creates the underlying structures of
 // LyricsSet - hashmap and linked list.
 func New(sizeLimit int) *LyricsSet {
@@ -29,8 +33,8 @@ func New(sizeLimit int) *LyricsSet {
 // to the end of the linked list. It checks if the size of
 // the set exceeded the limit, in which case it gets the
 // first element of linked list and removes it from both
-// hashmap and linked list.
-func (lset *LyricsSet) Put(keyArtist, keyTitle string, value string) {
+// hashmap and linked list. It reports whether a lyric was evicted.
+func (lset *LyricsSet) Put(keyArtist, keyTitle string, value string) bool {
 	key := metaLyric{keyArtist, keyTitle}
 	lset.hmap.Put(key, value)
 	lset.linkedList.Add(key)
@@ -43,8 +47,9 @@ func (lset *LyricsSet) Put(keyArtist, keyTitle string, value string) {
 		oldest := el.(metaLyric)
 		lset.hmap.Remove(oldest)
 		lset.linkedList.Remove(0)
-		fmt.Printf("Removed oldest lyric: %s - %s\n", oldest.artist, oldest.title)
+		return true
 	}
+	return false
 }
 
 // Get gets the value (the lyrics string) from the
@@ -68,4 +73,49 @@ func (lset *LyricsSet) Get(artist, title string) (string, bool) {
 	}
 
 	return el.(string), true
-}
\ No newline at end of file
+}
+
+// Contains reports whether the lyric is in the set
+// without moving it in the linked list, so that looking
+// doesn't count as a use.
+func (lset *LyricsSet) Contains(artist, title string) bool {
+	_, ok := lset.hmap.Get(metaLyric{artist, title})
+	return ok
+}
+
+// Remove deletes the lyric from both the hashmap and the
+// linked list. It reports whether the lyric was in the set.
+func (lset *LyricsSet) Remove(artist, title string) bool {
+	key := metaLyric{artist, title}
+	if _, ok := lset.hmap.Get(key); !ok {
+		return false
+	}
+
+	lset.hmap.Remove(key)
+	if i := lset.linkedList.IndexOf(key); i >= 0 {
+		lset.linkedList.Remove(i)
+	}
+	return true
+}
+
+// Size returns the number of lyrics in the set.
+func (lset *LyricsSet) Size() int {
+	return lset.hmap.Size()
+}
+
+// Limit returns the maximum number of lyrics the set holds.
+func (lset *LyricsSet) Limit() int {
+	return lset.sizeLimit
+}
+
+// Entries lists the lyrics in the set from the
+// least to the most recently used one.
+func (lset *LyricsSet) Entries() []Entry {
+	entries := make([]Entry, 0, lset.linkedList.Size())
+	it := lset.linkedList.Iterator()
+	for it.Next() {
+		key := it.Value().(metaLyric)
+		entries = append(entries, Entry{key.artist, key.title})
+	}
+	return entries
+}
@@ -0,0 +1,42 @@
+package lyricTreeSet
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEviction(t *testing.T) {
+	tests := []struct {
+		name string
+		use  func(*LyricsSet)
+		want []Entry
+	}{
+		{"oldest goes", func(*LyricsSet) {}, []Entry{{"B", "b"}, {"C", "c"}}},
+		{"Get keeps", func(s *LyricsSet) { s.Get("A", "a") }, []Entry{{"A", "a"}, {"C", "c"}}},
+		{"Contains doesn't", func(s *LyricsSet) { s.Contains("A", "a") }, []Entry{{"B", "b"}, {"C", "c"}}},
+	}
+	for _, tt := range tests {
+		s := New(2)
+		s.Put("A", "a", "lyrics a")
+		s.Put("B", "b", "lyrics b")
+		tt.use(s)
+		if !s.Put("C", "c", "lyrics c") {
+			t.Errorf("%s: nothing evicted", tt.name)
+		}
+		if got := s.Entries(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestContains(t *testing.T) {
+	s := New(2)
+	s.Put("A", "a", "lyrics a")
+	if !s.Contains("A", "a") || s.Contains("A", "b") {
+		t.Errorf("Contains is wrong")
+	}
+	s.Remove("A", "a")
+	if s.Contains("A", "a") {
+		t.Errorf("removed lyric still contained")
+	}
+}
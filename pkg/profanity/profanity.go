@@ -0,0 +1,121 @@
+// Package profanity masks swear words in lyrics, with a word list per
+// language.
+package profanity
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Filter masks the words of its lists. A list entry ending in "*"
+// matches every word starting with the rest of it.
+type Filter struct {
+	words    map[string]map[string]bool // language -> words
+	prefixes map[string][]string        // language -> prefixes
+}
+
+// New builds a filter from word lists by ISO 639-1 language code.
+func New(lists map[string][]string) *Filter {
+	f := &Filter{words: make(map[string]map[string]bool), prefixes: make(map[string][]string)}
+	for lang, list := range lists {
+		f.words[lang] = make(map[string]bool)
+		for _, w := range list {
+			w = strings.ToLower(strings.TrimSpace(w))
+			switch {
+			case w == "" || w == "*":
+			case strings.HasSuffix(w, "*"):
+				f.prefixes[lang] = append(f.prefixes[lang], strings.TrimSuffix(w, "*"))
+			default:
+				f.words[lang][w] = true
+			}
+		}
+	}
+	return f
+}
+
+// ReadList reads a word list with one entry per line. Blank lines and
+// lines starting with # are skipped.
+func ReadList(r io.Reader) ([]string, error) {
+	var list []string
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		if line := strings.TrimSpace(s.Text()); line != "" && !strings.HasPrefix(line, "#") {
+			list = append(list, line)
+		}
+	}
+	return list, s.Err()
+}
+
+// Mask replaces all but the first letter of every listed word of text
+// with asterisks. It checks the list of lang and the English one, as
+// lyrics in other languages often mix in English, or every list when
+// there is none for lang.
+func (f *Filter) Mask(text, lang string) string {
+	langs := []string{lang, "en"}
+	if _, ok := f.words[lang]; !ok {
+		langs = langs[:0]
+		for l := range f.words {
+			langs = append(langs, l)
+		}
+	}
+
+	var b strings.Builder
+	start := -1 // of the current word
+	for i, r := range text + " " {
+		if isWordRune(r) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start >= 0 {
+			b.WriteString(f.maskWord(text[start:i], langs))
+			start = -1
+		}
+		if i < len(text) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+func (f *Filter) maskWord(w string, langs []string) string {
+	if !f.listed(strings.ToLower(w), langs) {
+		return w
+	}
+	_, size := utf8.DecodeRuneInString(w)
+	return w[:size] + strings.Repeat("*", utf8.RuneCountInString(w)-1)
+}
+
+func (f *Filter) listed(w string, langs []string) bool {
+	for _, lang := range langs {
+		if f.words[lang][w] {
+			return true
+		}
+		for _, p := range f.prefixes[lang] {
+			if strings.HasPrefix(w, p) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isWordRune leaves out apostrophes, so the parts of "shit's" or
+// "motherfuckin'" are checked on their own.
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsNumber(r)
+}
+
+// Defaults are the lists used for languages without one of their own.
+var Defaults = map[string][]string{
+	"en": {"fuck*", "motherfuck*", "shit", "shits", "shitty", "bullshit", "bitch", "bitches", "asshole*", "cunt*", "dick", "dicks", "pussy", "bastard*", "damn", "goddamn", "nigga*", "whore*", "slut*"},
+	"es": {"puta*", "puto*", "mierda", "coño", "joder", "cabrón", "cabron", "pendejo*", "verga", "chingar*", "chinga*"},
+	"pt": {"porra", "caralho", "merda", "puta*", "foda*", "foder", "buceta"},
+	"fr": {"putain", "merde", "connard*", "salope*", "encul*"},
+	"de": {"scheiße", "scheisse", "scheiß*", "fick*", "arschloch", "hure*", "fotze"},
+	"it": {"cazzo", "merda", "vaffanculo", "stronzo*", "puttana*", "troia"},
+}
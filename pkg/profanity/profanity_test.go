@@ -0,0 +1,62 @@
+package profanity
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMask(t *testing.T) {
+	f := New(map[string][]string{
+		"en": {"shit", "fuck*", " Damn "},
+		"es": {"mierda", "puta*"},
+		"fr": {"merde"},
+	})
+
+	tests := []struct {
+		text, lang, want string
+	}{
+		{"Oh shit, SHIT!", "en", "Oh s***, S***!"},
+		{"that shit's fine", "en", "that s***'s fine"},
+		{"that shit’s fine", "en", "that s***’s fine"},
+		{"fuckin' motherfucker", "en", "f*****' motherfucker"},
+		{"don't damn me", "en", "don't d*** me"},
+		{"shitty", "en", "shitty"},
+		{"qué mierda, putas", "es", "qué m*****, p****"},
+		{"mierda y shit", "es", "m***** y s***"},
+		{"merde", "es", "merde"},
+		// without a list of its own every list is checked
+		{"merde, mierda, shit", "de", "m****, m*****, s***"},
+		{"merde", "", "m****"},
+		{"", "en", ""},
+	}
+	for _, tt := range tests {
+		if got := f.Mask(tt.text, tt.lang); got != tt.want {
+			t.Errorf("Mask(%q, %q) = %q, want %q", tt.text, tt.lang, got, tt.want)
+		}
+	}
+}
+
+func TestDefaults(t *testing.T) {
+	f := New(Defaults)
+	tests := []struct {
+		text, lang, want string
+	}{
+		{"shit's bullshit", "en", "s***'s b*******"},
+		{"Scheiße", "de", "S******"},
+		{"putain de merde", "fr", "p***** de m****"},
+		{"vaffanculo", "ko", "v*********"},
+	}
+	for _, tt := range tests {
+		if got := f.Mask(tt.text, tt.lang); got != tt.want {
+			t.Errorf("Mask(%q, %q) = %q, want %q", tt.text, tt.lang, got, tt.want)
+		}
+	}
+}
+
+func TestReadList(t *testing.T) {
+	list, err := ReadList(strings.NewReader("# swear words\nshit\n\n  fuck*  \n#damn\n"))
+	if err != nil || !reflect.DeepEqual(list, []string{"shit", "fuck*"}) {
+		t.Errorf("got %q, %v", list, err)
+	}
+}
@@ -0,0 +1,131 @@
+// Package staticAssets serves files under names that hold a hash of their
+// content, such as "main.1a2b3c4d.css", so that browsers can keep them
+// for good: a changed file gets a new name.
+package staticAssets
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	// hashed names never change content
+	immutable = "public, max-age=31536000, immutable"
+	// plain names are checked against their ETag on every use
+	revalidate = "no-cache"
+)
+
+type file struct {
+	name    string // as requested, hashed or not
+	hash    string
+	content []byte
+}
+
+// Assets serves the files of a file system. Unless it reloads, every file
+// is read and hashed once, when it is created.
+type Assets struct {
+	fsys   fs.FS
+	reload bool
+	byName map[string]file // by plain name, such as "main.css"
+	hashed map[string]string
+}
+
+// New reads every file of fsys. An Assets that reloads reads a file on
+// every request instead, and gives plain names, for editing them live.
+func New(fsys fs.FS, reload bool) (*Assets, error) {
+	a := &Assets{fsys: fsys, reload: reload, byName: make(map[string]file), hashed: make(map[string]string)}
+	if reload {
+		return a, nil
+	}
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		f, err := a.read(name)
+		if err != nil {
+			return err
+		}
+		f.name = hashedName(name, f.hash)
+		a.byName[name] = f
+		a.hashed[f.name] = name
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Must is a helper that wraps a call to New and panics on error, for
+// files built into the binary.
+func Must(a *Assets, err error) *Assets {
+	if err != nil {
+		panic(err)
+	}
+	return a
+}
+
+// Path returns the name to link the file with the given plain name by.
+func (a *Assets) Path(name string) (string, error) {
+	if a.reload {
+		if _, err := fs.Stat(a.fsys, name); err != nil {
+			return "", err
+		}
+		return name, nil
+	}
+	f, ok := a.byName[name]
+	if !ok {
+		return "", fmt.Errorf("static asset %q: %w", name, fs.ErrNotExist)
+	}
+	return f.name, nil
+}
+
+// ServeHTTP serves the file named by the request path, which has any
+// prefix stripped. Hashed names are cached for a year, plain ones are
+// revalidated with their ETag.
+func (a *Assets) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/")
+	f, cache, ok := a.lookup(name)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Cache-Control", cache)
+	w.Header().Set("ETag", `"`+f.hash+`"`)
+	// answers If-None-Match and sets the Content-Type from the extension
+	http.ServeContent(w, r, name, time.Time{}, bytes.NewReader(f.content))
+}
+
+func (a *Assets) lookup(name string) (file, string, bool) {
+	if a.reload {
+		f, err := a.read(name)
+		return f, revalidate, err == nil
+	}
+	if plain, ok := a.hashed[name]; ok {
+		return a.byName[plain], immutable, true
+	}
+	f, ok := a.byName[name]
+	return f, revalidate, ok
+}
+
+func (a *Assets) read(name string) (file, error) {
+	content, err := fs.ReadFile(a.fsys, name)
+	if err != nil {
+		return file{}, err
+	}
+	sum := sha256.Sum256(content)
+	return file{name: name, hash: hex.EncodeToString(sum[:]), content: content}, nil
+}
+
+// hashedName puts the start of the hash before the extension.
+func hashedName(name, hash string) string {
+	ext := path.Ext(name)
+	return strings.TrimSuffix(name, ext) + "." + hash[:8] + ext
+}
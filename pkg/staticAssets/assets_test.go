@@ -0,0 +1,115 @@
+package staticAssets
+
+import (
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+)
+
+func testFS() fstest.MapFS {
+	return fstest.MapFS{
+		"main.css":   {Data: []byte("body { color: red }")},
+		"js/app.js":  {Data: []byte("console.log(1)")},
+		"robots.txt": {Data: []byte("")},
+	}
+}
+
+func serve(a *Assets, path, etag string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("GET", path, nil)
+	if etag != "" {
+		r.Header.Set("If-None-Match", etag)
+	}
+	w := httptest.NewRecorder()
+	a.ServeHTTP(w, r)
+	return w
+}
+
+func TestPath(t *testing.T) {
+	a, err := New(testFS(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]string{
+		"main.css":   "main.925e8741.css",
+		"js/app.js":  "js/app.0a286891.js",
+		"robots.txt": "robots.e3b0c442.txt",
+	}
+	for name, want := range tests {
+		if got, err := a.Path(name); err != nil || got != want {
+			t.Errorf("Path(%q) = %q, %v, want %q", name, got, err, want)
+		}
+	}
+	if _, err := a.Path("missing.css"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("missing file: got %v", err)
+	}
+}
+
+func TestServeHTTP(t *testing.T) {
+	a, err := New(testFS(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hashed, _ := a.Path("main.css")
+
+	tests := []struct {
+		path   string
+		status int
+		cache  string
+	}{
+		{"/" + hashed, http.StatusOK, immutable},
+		{"/main.css", http.StatusOK, revalidate},
+		{"/main.00000000.css", http.StatusNotFound, ""},
+		{"/js", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		w := serve(a, tt.path, "")
+		if w.Code != tt.status || w.Header().Get("Cache-Control") != tt.cache {
+			t.Errorf("%s: got %d with Cache-Control %q, want %d with %q", tt.path, w.Code, w.Header().Get("Cache-Control"), tt.status, tt.cache)
+		}
+		if tt.status == http.StatusOK && (w.Body.String() != "body { color: red }" || w.Header().Get("Content-Type") != "text/css; charset=utf-8") {
+			t.Errorf("%s: got %q as %q", tt.path, w.Body, w.Header().Get("Content-Type"))
+		}
+	}
+
+	etag := serve(a, "/main.css", "").Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("no ETag")
+	}
+	if w := serve(a, "/main.css", etag); w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+		t.Errorf("matching ETag: got %d with %q", w.Code, w.Body)
+	}
+	if w := serve(a, "/main.css", `"stale"`); w.Code != http.StatusOK {
+		t.Errorf("stale ETag: got %d", w.Code)
+	}
+}
+
+func TestReload(t *testing.T) {
+	fsys := testFS()
+	a, err := New(fsys, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := a.Path("main.css"); err != nil || got != "main.css" {
+		t.Errorf("Path = %q, %v, want the plain name", got, err)
+	}
+	if _, err := a.Path("missing.css"); err == nil {
+		t.Errorf("missing file: got no error")
+	}
+
+	before := serve(a, "/main.css", "")
+	fsys["main.css"] = &fstest.MapFile{Data: []byte("body { color: blue }")}
+	fsys["new.css"] = &fstest.MapFile{Data: []byte("p {}")}
+	after := serve(a, "/main.css", before.Header().Get("ETag"))
+	if after.Code != http.StatusOK || after.Body.String() != "body { color: blue }" || after.Header().Get("Cache-Control") != revalidate {
+		t.Errorf("edited file: got %d %q with Cache-Control %q", after.Code, after.Body, after.Header().Get("Cache-Control"))
+	}
+	if after.Header().Get("ETag") == before.Header().Get("ETag") {
+		t.Errorf("ETag unchanged after an edit")
+	}
+	if w := serve(a, "/new.css", ""); w.Code != http.StatusOK {
+		t.Errorf("added file: got %d", w.Code)
+	}
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/zmb3/spotify"
+)
+
+const (
+	pollInterval    = 5 * time.Second // while a track plays
+	pollMinInterval = time.Second
+	pollEndMargin   = 500 * time.Millisecond // poll this long after a track should have ended
+	pollMaxInterval = 30 * time.Second       // backing off while paused or failing
+)
+
+// nowPlaying is what a user's player was doing when last polled.
+type nowPlaying struct {
+	Playing    bool      `json:"playing"`
+	TrackID    string    `json:"track_id,omitempty"`
+	Artist     string    `json:"artist,omitempty"`
+	Title      string    `json:"title,omitempty"`
+	Album      string    `json:"album,omitempty"`
+	ProgressMs int       `json:"progress_ms"`
+	DurationMs int       `json:"duration_ms"`
+	Explicit   bool      `json:"explicit,omitempty"`
+	DeviceName string    `json:"device_name,omitempty"`
+	DeviceType string    `json:"device_type,omitempty"`
+	Fetched    time.Time `json:"fetched"`
+	Error      string    `json:"error,omitempty"`
+
+	// LyricsHidden is set per stream, for explicit tracks in clean mode.
+	LyricsHidden bool `json:"lyrics_hidden,omitempty"`
+}
+
+// pollerHub runs one poller per Spotify user, shared by all of
+// that user's tabs and streams. played is told whenever a user's
+// player starts playing another track.
+type pollerHub struct {
+	poll   func(*spotify.Client) (nowPlaying, error)
+	played func(userID string, np nowPlaying)
+
+	mutex   sync.Mutex
+	pollers map[string]*poller // by Spotify user ID
+}
+
+// poller polls one user's player for as long as somebody subscribes
+// to it, and sends every result to all subscribers.
+type poller struct {
+	hub    *pollerHub
+	userID string
+	wake   chan struct{}
+
+	mutex  sync.Mutex
+	client *spotify.Client
+	subs   map[chan nowPlaying]bool
+	last   *nowPlaying
+}
+
+func newPollerHub(poll func(*spotify.Client) (nowPlaying, error), played func(string, nowPlaying)) *pollerHub {
+	return &pollerHub{poll: poll, played: played, pollers: make(map[string]*poller)}
+}
+
+// subscribe starts receiving the user's player state, starting the
+// user's poller if needed. The client replaces the poller's, so it
+// always polls with the freshest token. The channel only ever holds
+// the latest state; call unsubscribe when done.
+func (h *pollerHub) subscribe(userID string, client *spotify.Client) (updates <-chan nowPlaying, unsubscribe func()) {
+	ch := make(chan nowPlaying, 1)
+
+	h.mutex.Lock()
+	p, ok := h.pollers[userID]
+	if !ok {
+		p = &poller{
+			hub:    h,
+			userID: userID,
+			wake:   make(chan struct{}, 1),
+			subs:   make(map[chan nowPlaying]bool),
+		}
+		h.pollers[userID] = p
+		go p.run()
+	}
+	p.mutex.Lock()
+	p.client = client
+	p.subs[ch] = true
+	if p.last != nil {
+		ch <- *p.last
+	}
+	p.mutex.Unlock()
+	h.mutex.Unlock()
+
+	var once sync.Once
+	return ch, func() { once.Do(func() { p.unsubscribe(ch) }) }
+}
+
+// size is the number of running pollers.
+func (h *pollerHub) size() int {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return len(h.pollers)
+}
+
+func (p *poller) unsubscribe(ch chan nowPlaying) {
+	p.mutex.Lock()
+	delete(p.subs, ch)
+	idle := len(p.subs) == 0
+	p.mutex.Unlock()
+
+	if idle {
+		select {
+		case p.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (p *poller) run() {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	backoff := pollInterval
+	var lastTrack string // the last track seen playing
+
+	for {
+		select {
+		case <-timer.C:
+		case <-p.wake:
+			if p.stopIfIdle() {
+				return
+			}
+			continue
+		}
+		if p.stopIfIdle() {
+			return
+		}
+
+		p.mutex.Lock()
+		client := p.client
+		p.mutex.Unlock()
+
+		np, err := p.hub.poll(client)
+		if err != nil {
+			np.Error = err.Error()
+		}
+		np.Fetched = time.Now()
+		p.publish(np)
+		if np.Playing && np.TrackID != "" && np.TrackID != lastTrack {
+			lastTrack = np.TrackID
+			p.hub.played(p.userID, np)
+		}
+
+		var next time.Duration
+		next, backoff = nextPoll(np, err, backoff)
+		timer.Reset(next)
+	}
+}
+
+// stopIfIdle removes the poller from the hub once nobody listens.
+// Holding the hub's lock makes sure no subscriber joins meanwhile.
+func (p *poller) stopIfIdle() bool {
+	p.hub.mutex.Lock()
+	defer p.hub.mutex.Unlock()
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if len(p.subs) > 0 {
+		return false
+	}
+	delete(p.hub.pollers, p.userID)
+	return true
+}
+
+// publish replaces whatever state subscribers have not read yet.
+func (p *poller) publish(np nowPlaying) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.last = &np
+	for ch := range p.subs {
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- np
+	}
+}
+
+// nextPoll picks when to poll again. While a track plays that is every
+// pollInterval, or just after the track ends if that is sooner, so
+// that the next track shows up quickly. While paused, idle or failing
+// the interval doubles up to pollMaxInterval. Rate limits and an open
+// circuit are waited out.
+func nextPoll(np nowPlaying, err error, backoff time.Duration) (next, newBackoff time.Duration) {
+	var limited *rateLimitError
+	var open *circuitOpenError
+	switch {
+	case errors.As(err, &limited):
+		return limited.retryAfter, backoff
+	case errors.As(err, &open):
+		return open.retryAfter, backoff
+	case err != nil || !np.Playing:
+		backoff *= 2
+		if backoff > pollMaxInterval {
+			backoff = pollMaxInterval
+		}
+		return backoff, backoff
+	}
+
+	next = pollInterval
+	remaining := time.Duration(np.DurationMs-np.ProgressMs)*time.Millisecond + pollEndMargin
+	if remaining < next {
+		next = remaining
+	}
+	if next < pollMinInterval {
+		next = pollMinInterval
+	}
+	return next, pollInterval
+}
+
+// pollPlayer gets the player state of the client's user.
+func (a *App) pollPlayer(client *spotify.Client) (nowPlaying, error) {
+	ctx := context.Background()
+	done := a.startSpotifyCall(ctx, "PlayerState")
+	state, err := client.PlayerState()
+	done(err)
+	if err != nil {
+		return nowPlaying{}, err
+	}
+	a.prefetch.upcoming(ctx, client, state)
+
+	np := nowPlaying{
+		Playing:    state.Playing,
+		ProgressMs: state.Progress,
+		DeviceName: state.Device.Name,
+		DeviceType: state.Device.Type,
+	}
+	if item := state.Item; item != nil {
+		np.TrackID = string(item.ID)
+		np.Title = item.Name
+		np.DurationMs = item.Duration
+		np.Album = item.Album.Name
+		np.Explicit = item.Explicit
+		if len(item.Artists) > 0 {
+			np.Artist = item.Artists[0].Name
+		}
+		a.lyrics.index.SetURI(np.Artist, np.Title, string(item.URI))
+		a.lyrics.noteMarkets(np.Artist, np.Title, item.AvailableMarkets)
+	}
+	return np, nil
+}
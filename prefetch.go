@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zmb3/spotify"
+	"spotify-live-lyricist/pkg/logging"
+)
+
+const (
+	prefetchMemory = 10 * time.Minute // how long a context and track are not prefetched again
+	pageSize       = 50               // tracks per page when listing playlists, albums and the library
+)
+
+// prefetcher warms the lyrics cache for the tracks that come after the
+// current one in the playlist or album being played, so that the next
+// song doesn't wait for a scrape.
+type prefetcher struct {
+	lyrics    *lyricsService
+	metrics   *metrics
+	startCall func(context.Context, string) func(error) // traces and counts Spotify calls
+	ahead     int
+	workers   chan struct{} // limits concurrent lyric fetches across all users
+
+	mutex     sync.Mutex
+	seen      map[string]time.Time // context URI and track ID -> when prefetched
+	positions map[string]position  // context URI -> where to look for the next track
+	inflight  map[trackRef]bool
+}
+
+// position is the offset in a playlist or album at which the next
+// track is expected.
+type position struct {
+	offset int
+	at     time.Time
+}
+
+// trackRef identifies a track in a playlist, an album or the library.
+type trackRef struct {
+	ID                   spotify.ID
+	Artist, Title, Album string
+}
+
+func newPrefetcher(lyrics *lyricsService, m *metrics, startCall func(context.Context, string) func(error), ahead, workers int) *prefetcher {
+	return &prefetcher{
+		lyrics:    lyrics,
+		metrics:   m,
+		startCall: startCall,
+		ahead:     ahead,
+		workers:   make(chan struct{}, workers),
+		seen:      make(map[string]time.Time),
+		positions: make(map[string]position),
+		inflight:  make(map[trackRef]bool),
+	}
+}
+
+// upcoming prefetches, in the background, the lyrics of the tracks
+// following the one in state. It does nothing outside of a playlist
+// or album, or if it already did for this context and track lately.
+//
+// Spotify doesn't say where the current track is in its context, so
+// upcoming lists a single page: for the first disc of an album, the one
+// starting at the track's number; otherwise the one where the previous
+// track was followed, or else the next page not looked through yet.
+// That is one call per track change, on the same rate limit as the player.
+func (p *prefetcher) upcoming(ctx context.Context, client *spotify.Client, state *spotify.PlayerState) {
+	if p.ahead == 0 || state.Item == nil {
+		return
+	}
+	uri := string(state.PlaybackContext.URI)
+	if uri == "" || !p.markSeen(uri+"|"+string(state.Item.ID)) {
+		return
+	}
+
+	ctx = context.WithoutCancel(ctx)
+	current := state.Item.ID
+	offset := p.position(uri)
+	if state.PlaybackContext.Type == "album" && state.Item.DiscNumber <= 1 {
+		// track numbers start again on every disc
+		offset = state.Item.TrackNumber - 1
+	}
+	go func() {
+		tracks, total, err := contextPage(ctx, client, state.PlaybackContext, offset, p.startCall)
+		if err != nil {
+			logging.FromContext(ctx).Warn("Listing tracks to prefetch", "context", uri, "err", err)
+			return
+		}
+		i := indexOf(tracks, current)
+		if i < 0 {
+			// look through the next page after the next track change
+			next := offset + pageSize
+			if next >= total {
+				next = 0
+			}
+			p.setPosition(uri, next)
+			return
+		}
+		p.setPosition(uri, offset+i+1)
+		for _, t := range after(tracks, i, p.ahead) {
+			p.prefetch(ctx, t)
+		}
+	}()
+}
+
+// position returns the offset at which to look for the next track of
+// the context uri, 0 if there is none yet.
+func (p *prefetcher) position(uri string) int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if pos, ok := p.positions[uri]; ok && time.Since(pos.at) < prefetchMemory {
+		return pos.offset
+	}
+	return 0
+}
+
+func (p *prefetcher) setPosition(uri string, offset int) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.positions[uri] = position{offset, time.Now()}
+}
+
+// later prefetches tracks in the background, one after the other.
+func (p *prefetcher) later(ctx context.Context, tracks []trackRef) {
+	if p.ahead == 0 || len(tracks) == 0 {
+		return
+	}
+	ctx = context.WithoutCancel(ctx)
+	go func() {
+		for _, t := range tracks {
+			p.prefetch(ctx, t)
+		}
+	}()
+}
+
+// markSeen reports whether key was not seen within prefetchMemory,
+// and remembers it.
+func (p *prefetcher) markSeen(key string) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	now := time.Now()
+	if t, ok := p.seen[key]; ok && now.Sub(t) < prefetchMemory {
+		return false
+	}
+	for k, t := range p.seen {
+		if now.Sub(t) >= prefetchMemory {
+			delete(p.seen, k)
+		}
+	}
+	for k, pos := range p.positions {
+		if now.Sub(pos.at) >= prefetchMemory {
+			delete(p.positions, k)
+		}
+	}
+	p.seen[key] = now
+	return true
+}
+
+// prefetch fetches a track's lyrics into the cache unless they are
+// already there, corrected or being fetched.
+func (p *prefetcher) prefetch(ctx context.Context, t trackRef) {
+	if p.lyrics.cached(t.Artist, t.Title) {
+		p.metrics.prefetches.WithLabelValues("cached").Inc()
+		return
+	}
+	if _, err := p.lyrics.store.ApprovedRevision(ctx, t.Artist, t.Title); err == nil {
+		p.metrics.prefetches.WithLabelValues("corrected").Inc()
+		return
+	}
+
+	p.mutex.Lock()
+	if p.inflight[t] {
+		p.mutex.Unlock()
+		return
+	}
+	p.inflight[t] = true
+	p.mutex.Unlock()
+
+	p.workers <- struct{}{}
+	go func() {
+		defer func() {
+			<-p.workers
+			p.mutex.Lock()
+			delete(p.inflight, t)
+			p.mutex.Unlock()
+		}()
+
+		ctx, span := tracer.Start(ctx, "prefetchLyrics")
+		defer span.End()
+		lyrics, source, err := p.lyrics.getLyrics(ctx, t.Artist, t.Title)
+		if err != nil {
+			p.metrics.prefetches.WithLabelValues("not_found").Inc()
+			return
+		}
+		p.lyrics.put(t.Artist, t.Title, lyrics, source)
+		p.metrics.prefetches.WithLabelValues("fetched").Inc()
+	}()
+}
+
+// indexOf returns the index of the track id in tracks, or -1.
+func indexOf(tracks []trackRef, id spotify.ID) int {
+	for i, t := range tracks {
+		if t.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// after returns up to n tracks after tracks[i].
+func after(tracks []trackRef, i, n int) []trackRef {
+	tracks = tracks[i+1:]
+	if len(tracks) > n {
+		tracks = tracks[:n]
+	}
+	return tracks
+}
+
+// contextPage lists a page of the playlist or album being played, from
+// offset, with the number of tracks in it. Other contexts (artists,
+// shows, ...) have no fixed order and give none.
+func contextPage(ctx context.Context, client *spotify.Client, pc spotify.PlaybackContext, offset int, startCall func(context.Context, string) func(error)) ([]trackRef, int, error) {
+	parts := strings.Split(string(pc.URI), ":")
+	id := spotify.ID(parts[len(parts)-1])
+	limit := pageSize
+	opt := &spotify.Options{Limit: &limit, Offset: &offset}
+
+	var tracks []trackRef
+	switch pc.Type {
+	case "playlist":
+		done := startCall(ctx, "GetPlaylistTracks")
+		page, err := client.GetPlaylistTracksOpt(id, opt, "")
+		done(err)
+		if err != nil {
+			return nil, 0, err
+		}
+		for _, pt := range page.Tracks {
+			t := trackOf(pt.Track.SimpleTrack)
+			t.Album = pt.Track.Album.Name
+			tracks = append(tracks, t)
+		}
+		return tracks, page.Total, nil
+	case "album":
+		done := startCall(ctx, "GetAlbumTracks")
+		page, err := client.GetAlbumTracksOpt(id, opt)
+		done(err)
+		if err != nil {
+			return nil, 0, err
+		}
+		for _, t := range page.Tracks {
+			tracks = append(tracks, trackOf(t))
+		}
+		return tracks, page.Total, nil
+	}
+	return nil, 0, nil
+}
+
+func trackOf(t spotify.SimpleTrack) trackRef {
+	ref := trackRef{ID: t.ID, Title: t.Name}
+	if len(t.Artists) > 0 {
+		ref.Artist = t.Artists[0].Name
+	}
+	return ref
+}
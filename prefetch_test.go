@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/zmb3/spotify"
+	"spotify-live-lyricist/pkg/fakeSpotify"
+)
+
+func TestPrefetchPlaylist(t *testing.T) {
+	app, fake, srv := newTestApp(t)
+	c := loggedIn(t, fake, srv)
+
+	var tracks []spotify.FullTrack
+	for i := 0; i < 120; i++ {
+		tracks = append(tracks, fakeSpotify.Track(fmt.Sprint("t", i), "Artist", fmt.Sprint("Song ", i), 3*time.Minute))
+	}
+	fake.AddPlaylist("pl", tracks...)
+	store := app.lyrics.store
+	ctx := context.Background()
+	rev := revision{ID: "r1", Artist: "Artist", Title: "Song 64", Text: "corrected", Status: revisionApproved}
+	store.AddRevision(ctx, rev)
+	store.ModerateRevision(ctx, rev, false)
+
+	// the first page is looked through, then the second after the next
+	// track change; from there on each change lists the page that
+	// follows the previous track
+	for _, step := range []struct{ track, next int }{{60, 50}, {61, 62}, {62, 63}} {
+		fake.PlayFrom(fakeSpotify.DefaultUser, "spotify:playlist:pl", tracks[step.track])
+		get(t, c, srv.URL+"/")
+		waitFor(t, func() bool { return app.prefetch.position("spotify:playlist:pl") == step.next })
+	}
+	if calls := fake.Calls("/v1/playlists/"); calls != 3 {
+		t.Errorf("listed %d pages, want 3", calls)
+	}
+	want := map[string]bool{"Song 62": true, "Song 63": true, "Song 64": false, "Song 67": true, "Song 68": false}
+	waitFor(t, func() bool {
+		for title, ok := range want {
+			if app.lyrics.cached("Artist", title) != ok {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+func TestPrefetchAlbum(t *testing.T) {
+	app, fake, srv := newTestApp(t)
+	c := loggedIn(t, fake, srv)
+
+	var tracks []spotify.FullTrack
+	for i := 0; i < 80; i++ {
+		track := fakeSpotify.Track(fmt.Sprint("t", i), "Artist", fmt.Sprint("Song ", i), 3*time.Minute)
+		track.TrackNumber = i + 1
+		tracks = append(tracks, track)
+	}
+	fake.AddAlbum("al", tracks...)
+
+	fake.PlayFrom(fakeSpotify.DefaultUser, "spotify:album:al", tracks[70])
+	get(t, c, srv.URL+"/")
+	waitFor(t, func() bool { return app.lyrics.cached("Artist", "Song 75") })
+	if calls := fake.Calls("/v1/albums/"); calls != 1 {
+		t.Errorf("listed %d pages, want 1", calls)
+	}
+}
+
+func TestPrefetchAlbumDiscs(t *testing.T) {
+	app, fake, srv := newTestApp(t)
+	c := loggedIn(t, fake, srv)
+
+	// the first disc is longer than a page, so the second disc's
+	// track numbers point at the wrong page
+	var tracks []spotify.FullTrack
+	for i := 0; i < 80; i++ {
+		track := fakeSpotify.Track(fmt.Sprint("t", i), "Artist", fmt.Sprint("Song ", i), 3*time.Minute)
+		track.DiscNumber, track.TrackNumber = 1, i+1
+		if i >= 60 {
+			track.DiscNumber, track.TrackNumber = 2, i-59
+		}
+		tracks = append(tracks, track)
+	}
+	fake.AddAlbum("al", tracks...)
+
+	for _, track := range []int{57, 62} {
+		fake.PlayFrom(fakeSpotify.DefaultUser, "spotify:album:al", tracks[track])
+		get(t, c, srv.URL+"/")
+		waitFor(t, func() bool { return app.prefetch.position("spotify:album:al") == track+1 })
+	}
+	waitFor(t, func() bool { return app.lyrics.cached("Artist", "Song 67") })
+	if calls := fake.Calls("/v1/albums/"); calls != 2 {
+		t.Errorf("listed %d pages, want 2", calls)
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); !cond(); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+	}
+}
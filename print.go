@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"spotify-live-lyricist/pkg/lyricSheet"
+	"spotify-live-lyricist/pkg/lyricSync"
+)
+
+const maxArtSize = 5 << 20 // bytes
+
+var artClient = &http.Client{Timeout: 5 * time.Second}
+
+// printOptions reads the size and columns parameters, falling back to
+// the configured defaults and keeping them within the same bounds.
+func (a *App) printOptions(r *http.Request) lyricSheet.Options {
+	o := lyricSheet.Options{FontSize: a.cfg.PrintFontSize, Columns: a.cfg.PrintColumns}
+	if n, err := strconv.Atoi(r.FormValue("size")); err == nil && n >= 6 && n <= 36 {
+		o.FontSize = n
+	}
+	if n, err := strconv.Atoi(r.FormValue("columns")); err == nil && n >= 1 && n <= 4 {
+		o.Columns = n
+	}
+	return o
+}
+
+// currentSheet builds the sheet of the user's current track. It writes
+// the error response itself when it fails.
+func (a *App) currentSheet(w http.ResponseWriter, r *http.Request) (*Result, *lyricSheet.Sheet, bool) {
+	client, _, err := a.getUser(w, r)
+	if err != nil {
+		return nil, nil, false
+	}
+	result, err := a.getSpotifyTrack(r.Context(), client, w)
+	if err != nil {
+		a.errors.record("spotify", err)
+		a.spotifyError(w, r, err)
+		return nil, nil, false
+	}
+
+	sheet := &lyricSheet.Sheet{
+		Title:  result.Title,
+		Artist: result.Artist,
+		Album:  result.Album,
+		Lyrics: lyricSync.Plain(a.shownLyrics(r.Context(), a.cleanMode(r), result)),
+	}
+	return result, sheet, true
+}
+
+// printPage shows the lyrics of the current track laid out for printing.
+func (a *App) printPage(w http.ResponseWriter, r *http.Request) {
+	result, sheet, ok := a.currentSheet(w, r)
+	if !ok {
+		return
+	}
+
+	err := a.tpl.ExecuteTemplate(w, "print.gohtml", struct {
+		*Result
+		Stanzas []lyricSheet.Stanza
+		Options lyricSheet.Options
+	}{result, lyricSheet.Stanzas(sheet.Lyrics), a.printOptions(r)})
+	if err != nil {
+		reqLogger(r).Error("Rendering print view", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// printPDF sends the lyric sheet of the current track as a PDF.
+func (a *App) printPDF(w http.ResponseWriter, r *http.Request) {
+	result, sheet, ok := a.currentSheet(w, r)
+	if !ok {
+		return
+	}
+	if result.AlbumArt != "" {
+		art, err := fetchArt(r.Context(), result.AlbumArt)
+		if err != nil {
+			// the sheet is still useful without the cover
+			reqLogger(r).Warn("Fetching album art", "url", result.AlbumArt, "err", err)
+		}
+		sheet.Art = art
+	}
+
+	var buf bytes.Buffer
+	if err := lyricSheet.PDF(&buf, sheet, a.printOptions(r)); err != nil {
+		reqLogger(r).Error("Rendering PDF", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", sheet.Artist+" - "+sheet.Title+".pdf"))
+	w.Write(buf.Bytes())
+}
+
+func fetchArt(ctx context.Context, url string) ([]byte, error) {
+	ctx, span := tracer.Start(ctx, "fetchAlbumArt")
+	defer span.End()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := artClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("album art: %s", resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxArtSize))
+}
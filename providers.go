@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rhnvrm/lyric-api-go/genius"
+	"github.com/rhnvrm/lyric-api-go/lyricswikia"
+	"github.com/rhnvrm/lyric-api-go/musixmatch"
+	"github.com/rhnvrm/lyric-api-go/songlyrics"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"spotify-live-lyricist/pkg/lyricText"
+)
+
+type fetcher interface {
+	Fetch(artist, song string) string
+}
+
+type lyricProvider struct {
+	name    string
+	fetcher fetcher
+}
+
+// providerStats accumulates the outcome of every fetch from a provider.
+type providerStats struct {
+	Name                string
+	Successes, Failures int
+	TotalLatency        time.Duration
+}
+
+// providerStatsSet keeps the stats of every provider by name.
+type providerStatsSet struct {
+	mutex  sync.Mutex
+	byName map[string]*providerStats
+}
+
+// defaultProviders are tried one by one, in the same order lyric-api-go
+// uses, followed by the providers that require setup.
+func defaultProviders(geniusToken string) []lyricProvider {
+	providers := []lyricProvider{
+		{"lyricswikia", lyricswikia.New()},
+		{"songlyrics", songlyrics.New()},
+		{"musixmatch", musixmatch.New()},
+	}
+	if geniusToken != "" {
+		providers = append(providers, lyricProvider{"genius", genius.New(geniusToken)})
+	}
+	return providers
+}
+
+// fetch calls the provider and records its outcome and latency.
+func (l *lyricsService) fetch(ctx context.Context, p lyricProvider, artist, title string) (string, bool) {
+	_, span := tracer.Start(ctx, "lyrics.fetch", trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("lyrics.provider", p.name)))
+	defer span.End()
+
+	start := time.Now()
+	lyric := lyricText.Sanitize(p.fetcher.Fetch(artist, title))
+	ok := len(lyric) > 5 // same threshold lyric-api-go uses to tell an empty page
+	l.recordProviderFetch(p.name, ok, time.Since(start))
+	span.SetAttributes(attribute.Bool("lyrics.found", ok))
+	return lyric, ok
+}
+
+func (l *lyricsService) recordProviderFetch(name string, ok bool, latency time.Duration) {
+	outcome := "found"
+	if !ok {
+		outcome = "not_found"
+	}
+	l.metrics.providerFetches.WithLabelValues(name, outcome).Inc()
+	l.metrics.providerDuration.WithLabelValues(name).Observe(latency.Seconds())
+
+	l.stats.mutex.Lock()
+	defer l.stats.mutex.Unlock()
+
+	stats, found := l.stats.byName[name]
+	if !found {
+		stats = &providerStats{Name: name}
+		l.stats.byName[name] = stats
+	}
+	if ok {
+		stats.Successes++
+	} else {
+		stats.Failures++
+	}
+	stats.TotalLatency += latency
+}
+
+// providerStats returns a copy of the stats sorted by provider name.
+func (l *lyricsService) providerStats() []providerStats {
+	l.stats.mutex.Lock()
+	defer l.stats.mutex.Unlock()
+
+	all := make([]providerStats, 0, len(l.stats.byName))
+	for _, stats := range l.stats.byName {
+		all = append(all, *stats)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Name < all[j].Name })
+	return all
+}
+
+func (s providerStats) SuccessRate() float64 {
+	if s.Successes+s.Failures == 0 {
+		return 0
+	}
+	return float64(s.Successes) / float64(s.Successes+s.Failures) * 100
+}
+
+func (s providerStats) AvgLatency() time.Duration {
+	if s.Successes+s.Failures == 0 {
+		return 0
+	}
+	return (s.TotalLatency / time.Duration(s.Successes+s.Failures)).Round(time.Millisecond)
+}
@@ -1,61 +1,337 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"github.com/gomodule/redigo/redis"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const sessionPrefix string = "session"
 const statePrefix 	string = "state"
 const lyricPrefix	string = "lyric"
+const revisionPrefix string = "revision"
+const pendingRevisions string = "revisions:pending"
+const historyPrefix string = "history"
+
+type redisStore struct {
+	pool    *redis.Pool
+	metrics *metrics
+}
 
 func newPool(address string) *redis.Pool {
 	return &redis.Pool{
 		MaxIdle: 80,
 		MaxActive: 12000,
+		IdleTimeout: 240 * time.Second,
 		Dial: func() (redis.Conn, error) {
-			c, err := redis.Dial("tcp", address)
-			if err != nil {
-				panic(err.Error())
-			}
-			return c, err
+			return redis.Dial("tcp", address,
+				redis.DialConnectTimeout(5*time.Second),
+				redis.DialReadTimeout(5*time.Second),
+				redis.DialWriteTimeout(5*time.Second))
 		},
 	}
 }
 
-func setSession(sessionId string, s session) error {
-	json, err := json.Marshal(s)
+func newRedisStore(pool *redis.Pool, m *metrics) *redisStore {
+	return &redisStore{pool, m}
+}
+
+// do runs a command on a connection from the pool, records its latency and traces it.
+// Dial errors surface here instead of at start-up, so the app keeps running
+// (and /readyz reports it) while Redis is down. A missing key is
+// reported as errNotFound.
+func (s *redisStore) do(ctx context.Context, command string, args ...interface{}) (interface{}, error) {
+	_, span := tracer.Start(ctx, "redis."+command, trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("db.system", "redis")))
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	start := time.Now()
+	reply, err := redis.DoContext(conn, ctx, command, args...)
+	s.metrics.redisDuration.WithLabelValues(command).Observe(time.Since(start).Seconds())
+	if err == nil && reply == nil {
+		err = errNotFound
+	}
+	if err == errNotFound {
+		endSpan(span, nil) // a missing key is not a failure
+	} else {
+		endSpan(span, err)
+	}
+	return reply, err
+}
+
+func (s *redisStore) Ping(ctx context.Context) error {
+	_, err := s.do(ctx, "PING")
+	return err
+}
+
+func (s *redisStore) Close() error {
+	return s.pool.Close()
+}
+
+func (s *redisStore) setJSON(ctx context.Context, key string, v interface{}, ttl int) error {
+	json, err := json.Marshal(v)
 	if err != nil {
 		return err
 	}
 
-	_, err = conn.Do("SETEX", sessionPrefix+":"+sessionId, sessionLength, json)
+	if ttl > 0 {
+		_, err = s.do(ctx, "SETEX", key, ttl, json)
+	} else {
+		_, err = s.do(ctx, "SET", key, json)
+	}
+	return err
+}
+
+func (s *redisStore) getJSON(ctx context.Context, key string, v interface{}) error {
+	tmp, err := redis.Bytes(s.do(ctx, "GET", key))
 	if err != nil {
 		return err
 	}
 
-	return nil
+	return json.Unmarshal(tmp, v)
+}
+
+func (s *redisStore) SetSession(ctx context.Context, sessionId string, sesh session) error {
+	return s.setJSON(ctx, sessionPrefix+":"+sessionId, sesh, sessionLength)
+}
+
+func (s *redisStore) GetSession(ctx context.Context, sessionId string) (*session, error) {
+	sesh := session{}
+	if err := s.getJSON(ctx, sessionPrefix+":"+sessionId, &sesh); err != nil {
+		return nil, err
+	}
+	return &sesh, nil
+}
+
+func (s *redisStore) DeleteSession(ctx context.Context, sessionId string) error {
+	_, err := s.do(ctx, "DEL", sessionPrefix+":"+sessionId)
+	return err
 }
 
-func getSessionFromRedis(sessionId string) (*session, error) {
-	tmp, err := redis.String(conn.Do("GET", sessionPrefix+":"+sessionId))
+// SessionIDs scans the store for the IDs of all unexpired sessions.
+func (s *redisStore) SessionIDs(ctx context.Context) ([]string, error) {
+	var ids []string
+	cursor := 0
+	for {
+		values, err := redis.Values(s.do(ctx, "SCAN", cursor, "MATCH", sessionPrefix+":*", "COUNT", 100))
+		if err != nil {
+			return nil, err
+		}
+
+		cursor, err = redis.Int(values[0], nil)
+		if err != nil {
+			return nil, err
+		}
+		keys, err := redis.Strings(values[1], nil)
+		if err != nil {
+			return nil, err
+		}
+		for _, k := range keys {
+			ids = append(ids, strings.TrimPrefix(k, sessionPrefix+":"))
+		}
+
+		if cursor == 0 {
+			return ids, nil
+		}
+	}
+}
+
+func (s *redisStore) GetSessions(ctx context.Context, ids []string) (map[string]session, error) {
+	sessions := make(map[string]session, len(ids))
+	if len(ids) == 0 {
+		return sessions, nil
+	}
+
+	keys := make([]interface{}, len(ids))
+	for i, id := range ids {
+		keys[i] = sessionPrefix + ":" + id
+	}
+	values, err := redis.ByteSlices(s.do(ctx, "MGET", keys...))
 	if err != nil {
 		return nil, err
 	}
 
-	s := session{}
-	err = json.Unmarshal([]byte(tmp), &s)
+	for i, v := range values {
+		if v == nil {
+			continue // expired since it was listed
+		}
+		sesh := session{}
+		if err := json.Unmarshal(v, &sesh); err != nil {
+			return nil, err
+		}
+		sessions[ids[i]] = sesh
+	}
+	return sessions, nil
+}
+
+func (s *redisStore) SetState(ctx context.Context, id, state string) error {
+	_, err := s.do(ctx, "SETEX", statePrefix+":"+id, sessionLength, state)
+	return err
+}
+
+func (s *redisStore) GetState(ctx context.Context, id string) (string, error) {
+	return redis.String(s.do(ctx, "GET", statePrefix+":"+id))
+}
+
+func (s *redisStore) DeleteState(ctx context.Context, id string) error {
+	_, err := s.do(ctx, "DEL", statePrefix+":"+id)
+	return err
+}
+
+func (s *redisStore) GetRevision(ctx context.Context, id string) (*revision, error) {
+	rev := revision{}
+	if err := s.getJSON(ctx, revisionPrefix+":"+id, &rev); err != nil {
+		return nil, err
+	}
+	return &rev, nil
+}
+
+// AddRevision saves a new revision, appends it to the track's revision
+// history and puts it in the moderation queue. The revision's version
+// is its position within the track's history.
+func (s *redisStore) AddRevision(ctx context.Context, rev revision) error {
+	version, err := redis.Int(s.do(ctx, "RPUSH", trackRevisionsKey(rev.Artist, rev.Title), rev.ID))
+	if err != nil {
+		return err
+	}
+
+	rev.Version = version
+	if err = s.setJSON(ctx, revisionPrefix+":"+rev.ID, rev, 0); err != nil {
+		return err
+	}
+
+	_, err = s.do(ctx, "RPUSH", pendingRevisions, rev.ID)
+	return err
+}
+
+func (s *redisStore) PendingRevisions(ctx context.Context) ([]revision, error) {
+	ids, err := redis.Strings(s.do(ctx, "LRANGE", pendingRevisions, 0, -1))
 	if err != nil {
 		return nil, err
 	}
 
-	return &s, nil
+	revs := make([]revision, 0, len(ids))
+	for _, id := range ids {
+		rev, err := s.GetRevision(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		revs = append(revs, *rev)
+	}
+
+	return revs, nil
 }
 
-func deleteSession(sessionId string) error {
-	_, err := conn.Do("DEL", sessionPrefix+sessionId)
+// moderateScript stores a decision on a revision if it is still
+// pending and, unless ARGV[4] is set, if the track has no newer approved
+// revision. The approved revision is read from its key, named after the
+// override's value, which is fine outside of Redis Cluster.
+const moderateScript = `
+local pending = false
+for _, id in ipairs(redis.call('LRANGE', KEYS[2], 0, -1)) do
+	if id == ARGV[1] then pending = true break end
+end
+if not pending then return 'moderated' end
+if ARGV[3] == 'approved' and ARGV[4] ~= '1' then
+	local current = redis.call('GET', KEYS[3])
+	local approved = current and redis.call('GET', ARGV[6] .. current)
+	if approved and cjson.decode(approved).Version > tonumber(ARGV[5]) then return 'outdated' end
+end
+redis.call('SET', KEYS[1], ARGV[2])
+redis.call('LREM', KEYS[2], 0, ARGV[1])
+if ARGV[3] == 'approved' then redis.call('SET', KEYS[3], ARGV[1]) end
+return 'ok'
+`
+
+// ModerateRevision stores the reviewed revision and takes it out of the
+// moderation queue. Approved revisions become the lyrics override of their track.
+func (s *redisStore) ModerateRevision(ctx context.Context, rev revision, replace bool) error {
+	data, err := json.Marshal(rev)
 	if err != nil {
 		return err
 	}
+	replaceArg := "0"
+	if replace {
+		replaceArg = "1"
+	}
+
+	result, err := redis.String(s.do(ctx, "EVAL", moderateScript, 3,
+		revisionPrefix+":"+rev.ID, pendingRevisions, lyricOverrideKey(rev.Artist, rev.Title),
+		rev.ID, data, rev.Status, replaceArg, rev.Version, revisionPrefix+":"))
+	switch {
+	case err != nil:
+		return err
+	case result == "moderated":
+		return errModerated
+	case result == "outdated":
+		return errOutdated
+	}
 	return nil
-}
\ No newline at end of file
+}
+
+// ApprovedRevision returns the latest approved revision of a track,
+// or errNotFound if the lyrics of the track were never corrected.
+func (s *redisStore) ApprovedRevision(ctx context.Context, artist, title string) (*revision, error) {
+	id, err := redis.String(s.do(ctx, "GET", lyricOverrideKey(artist, title)))
+	if err != nil {
+		return nil, err
+	}
+
+	return s.GetRevision(ctx, id)
+}
+
+// AddPlays adds plays to the user's history, a sorted set scored by
+// the time of each play in milliseconds.
+func (s *redisStore) AddPlays(ctx context.Context, userID string, plays []play) error {
+	if len(plays) == 0 {
+		return nil
+	}
+	key := historyPrefix + ":" + userID
+	args := []interface{}{key}
+	for _, p := range plays {
+		member, err := json.Marshal(p)
+		if err != nil {
+			return err
+		}
+		args = append(args, p.PlayedAt.UnixNano()/int64(time.Millisecond), member)
+	}
+	if _, err := s.do(ctx, "ZADD", args...); err != nil {
+		return err
+	}
+
+	_, err := s.do(ctx, "ZREMRANGEBYRANK", key, 0, -historyLimit-1)
+	return err
+}
+
+func (s *redisStore) History(ctx context.Context, userID string, limit int) ([]play, error) {
+	members, err := redis.ByteSlices(s.do(ctx, "ZREVRANGE", historyPrefix+":"+userID, 0, limit-1))
+	if err == errNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	plays := make([]play, 0, len(members))
+	for _, m := range members {
+		var p play
+		if err := json.Unmarshal(m, &p); err != nil {
+			return nil, err
+		}
+		plays = append(plays, p)
+	}
+	return plays, nil
+}
+
+func trackRevisionsKey(artist, title string) string {
+	return revisionPrefix + "s:" + artist + ":" + title
+}
+
+func lyricOverrideKey(artist, title string) string {
+	return lyricPrefix + ":" + artist + ":" + title
+}
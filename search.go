@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zmb3/spotify"
+	"spotify-live-lyricist/pkg/lyricLang"
+	"spotify-live-lyricist/pkg/lyricSearch"
+)
+
+const (
+	searchLimit        = 20
+	searchSaveInterval = time.Minute
+)
+
+// searchPage shows the songs whose lyrics match q, with a button to
+// play each one.
+func (a *App) searchPage(w http.ResponseWriter, r *http.Request) {
+	q := strings.TrimSpace(r.FormValue("q"))
+	var hits []lyricSearch.Hit
+	if q != "" {
+		hits = a.cleanHits(a.cleanMode(r), a.lyrics.index.Search(q, searchLimit))
+	}
+
+	err := a.tpl.ExecuteTemplate(w, "search.gohtml", struct {
+		Query string
+		Hits  []lyricSearch.Hit
+		Size  int
+	}{q, hits, a.lyrics.index.Len()})
+	if err != nil {
+		reqLogger(r).Error("Rendering search", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// searchAPI returns the songs whose lyrics match q as JSON.
+func (a *App) searchAPI(w http.ResponseWriter, r *http.Request) {
+	q := strings.TrimSpace(r.FormValue("q"))
+	if q == "" {
+		http.Error(w, "Missing q", http.StatusBadRequest)
+		return
+	}
+
+	hits := a.cleanHits(a.cleanMode(r), a.lyrics.index.Search(q, searchLimit))
+	if hits == nil {
+		hits = []lyricSearch.Hit{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Query string            `json:"query"`
+		Hits  []lyricSearch.Hit `json:"hits"`
+	}{q, hits})
+}
+
+// cleanHits masks the snippets of hits in clean mode. The index doesn't
+// know the language of songs, so every word list applies.
+func (a *App) cleanHits(clean cleanMode, hits []lyricSearch.Hit) []lyricSearch.Hit {
+	for i := range hits {
+		for j := range hits[i].Snippet {
+			f := &hits[i].Snippet[j]
+			f.Text = a.clean(clean, f.Text, lyricLang.Unknown)
+		}
+	}
+	return hits
+}
+
+// playSearchHit starts playing a track found by a search on the
+// user's active device. Given the hit's artist, title and line, it
+// starts at that line if the track's lyrics are synced.
+func (a *App) playSearchHit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	client, _, err := a.getUser(w, r)
+	if err != nil {
+		return
+	}
+
+	uri := spotify.URI(r.FormValue("uri"))
+	if !strings.HasPrefix(string(uri), "spotify:track:") {
+		http.Error(w, "Missing or invalid track URI", http.StatusBadRequest)
+		return
+	}
+
+	opt := &spotify.PlayOptions{URIs: []spotify.URI{uri}}
+	if line, err := strconv.Atoi(r.FormValue("line")); err == nil {
+		if start, ok := a.lineStart(r.Context(), r.FormValue("artist"), r.FormValue("title"), line, 0); ok {
+			opt.PositionMs = int(start / time.Millisecond)
+		}
+	}
+
+	done := a.startSpotifyCall(r.Context(), "Play")
+	err = client.PlayOpt(opt)
+	done(err)
+	if err != nil {
+		a.errors.record("spotify", err)
+		a.spotifyError(w, r, err)
+		return
+	}
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// saveSearchIndex saves the search index every searchSaveInterval
+// until ctx is done; main saves it a last time on exit.
+func (a *App) saveSearchIndex(ctx context.Context) {
+	ticker := time.NewTicker(searchSaveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := a.lyrics.index.Save(); err != nil {
+				a.logger.Error("Saving search index", "err", err)
+			}
+		}
+	}
+}
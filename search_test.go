@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"spotify-live-lyricist/pkg/lyricSearch"
+)
+
+func TestLookupIndexing(t *testing.T) {
+	app, _, _ := newTestApp(t)
+	ctx := context.Background()
+	index := app.lyrics.index
+	indexed := func() string {
+		d, _ := index.Get("Artist", "Song")
+		return d.Lyrics
+	}
+
+	// a miss indexes what the provider returns
+	app.lyrics.lookup(ctx, "Artist", "Song")
+	if indexed() != "la la la\nsecond line" {
+		t.Fatalf("miss indexed %q", indexed())
+	}
+
+	// a hit leaves the index alone, unless the song is missing from it
+	index.Add(lyricSearch.Doc{Artist: "Artist", Title: "Song", Lyrics: "changed elsewhere"})
+	app.lyrics.lookup(ctx, "Artist", "Song")
+	if indexed() != "changed elsewhere" {
+		t.Errorf("hit reindexed %q", indexed())
+	}
+	index.Remove("Artist", "Song")
+	app.lyrics.lookup(ctx, "Artist", "Song")
+	if indexed() != "la la la\nsecond line" {
+		t.Errorf("hit of a song missing from the index: %q", indexed())
+	}
+
+	// evicted lyrics leave the search too
+	index.SetURI("Artist", "Song", "spotify:track:t1")
+	app.lyrics.evict("Artist", "Song")
+	if _, ok := index.Get("Artist", "Song"); ok || len(index.Search("second line", 1)) != 0 {
+		t.Errorf("evicted lyrics still searchable")
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"spotify-live-lyricist/pkg/lyricSync"
+	"spotify-live-lyricist/pkg/lyricText"
+)
+
+// sectionView is a section of the player page. A heading alone that
+// repeats an earlier section shows the lines of that section.
+type sectionView struct {
+	lyricText.Section
+	Repeat bool // of an earlier section, collapsed when asked
+	Lines  []lineView
+}
+
+// lineView is a line of the player page. Clicking a timed line seeks to
+// repeat Repeat of line Index of the lyrics, so that the lines of a
+// chorus written once and sung three times lead to the right chorus.
+type lineView struct {
+	Index  int
+	Repeat int
+	Text   string
+	Timed  bool
+}
+
+// sectionViews lays out the sections of lyrics for the player page.
+func sectionViews(lyrics lyricSync.Lyrics, sections []lyricText.Section) []sectionView {
+	views := make([]sectionView, len(sections))
+	named := make(map[int]int) // repeats of a section by heading alone so far
+	for i, s := range sections {
+		views[i] = sectionView{Section: s, Repeat: s.First != i}
+
+		lines, repeat := s, 0
+		if len(s.Lines) == 0 && s.First != i {
+			named[s.First]++
+			lines, repeat = sections[s.First], named[s.First]
+		}
+		for j, text := range lines.Lines {
+			index := lines.Start + j
+			v := lineView{Index: index, Repeat: repeat, Text: text}
+			if index < len(lyrics.Lines) {
+				v.Timed = repeat < len(lyrics.Lines[index].Starts)
+			}
+			views[i].Lines = append(views[i].Lines, v)
+		}
+	}
+	return views
+}
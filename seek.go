@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/zmb3/spotify"
+	"spotify-live-lyricist/pkg/lyricSync"
+)
+
+// seekToLine moves playback of the current track to where a line of its
+// synced lyrics is sung. It takes the line's index and, optionally, the
+// repeat of the line to seek to (0 for the first time it is sung) and
+// the ID of the track the line belongs to, so that a click arriving
+// after the track changed does not seek the next one.
+func (a *App) seekToLine(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	client, _, err := a.getUser(w, r)
+	if err != nil {
+		return
+	}
+	line, err := strconv.Atoi(r.FormValue("line"))
+	if err != nil {
+		http.Error(w, "Missing or invalid line", http.StatusBadRequest)
+		return
+	}
+
+	done := a.startSpotifyCall(r.Context(), "PlayerState")
+	state, err := client.PlayerState()
+	done(err)
+	if err != nil {
+		a.errors.record("spotify", err)
+		a.spotifyError(w, r, err)
+		return
+	}
+	item := state.Item
+	if item == nil || len(item.Artists) == 0 {
+		http.Error(w, "Nothing is playing", http.StatusConflict)
+		return
+	}
+	if id := r.FormValue("track_id"); id != "" && spotify.ID(id) != item.ID {
+		http.Error(w, "The track changed", http.StatusConflict)
+		return
+	}
+
+	repeat, _ := strconv.Atoi(r.FormValue("repeat"))
+	start, ok := a.lineStart(r.Context(), item.Artists[0].Name, item.Name, line, repeat)
+	if !ok {
+		http.Error(w, fmt.Sprintf("No time known for line %d", line), http.StatusUnprocessableEntity)
+		return
+	}
+
+	positionMs := int(start / time.Millisecond)
+	done = a.startSpotifyCall(r.Context(), "Seek")
+	err = client.Seek(positionMs)
+	done(err)
+	if err != nil {
+		a.errors.record("spotify", err)
+		a.spotifyError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		PositionMs int `json:"position_ms"`
+	}{positionMs})
+}
+
+// lineStart returns when line i of a track's lyrics is sung for the
+// repeat-th time after the first, if its lyrics are synced.
+func (a *App) lineStart(ctx context.Context, artist, title string, i, repeat int) (time.Duration, bool) {
+	lyrics, ok := a.lyrics.lookup(ctx, artist, title)
+	if !ok {
+		return 0, false
+	}
+	return lyricSync.Parse(lyrics).Repeat(i, repeat)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/zmb3/spotify"
+	"golang.org/x/oauth2"
+)
+
+var spotifyScopes = []string{
+	spotify.ScopeUserReadPrivate,
+	spotify.ScopeUserReadCurrentlyPlaying,
+	spotify.ScopeUserReadPlaybackState,
+	spotify.ScopePlaylistReadPrivate,
+	spotify.ScopeUserLibraryRead,
+	spotify.ScopeUserModifyPlaybackState,
+	spotify.ScopeUserReadRecentlyPlayed,
+	spotify.ScopeUserTopRead,
+}
+
+// spotifyFactory runs the OAuth flow and builds Web API clients for
+// the users' tokens. Every request goes through httpClient, which
+// tests point at a fake Spotify.
+type spotifyFactory struct {
+	oauth      *oauth2.Config
+	httpClient *http.Client
+}
+
+// newSpotifyFactory uses httpClient for the token exchange and the
+// API calls; nil means an HTTP/1.1 client, as spotify.Authenticator uses.
+// Its transport is wrapped in a spotifyTransport shared by all clients.
+func newSpotifyFactory(redirectURI, clientID, secret string, httpClient *http.Client, m *metrics) *spotifyFactory {
+	if httpClient == nil {
+		httpClient = &http.Client{Transport: &http.Transport{
+			Proxy:        http.ProxyFromEnvironment,
+			TLSNextProto: map[string]func(authority string, c *tls.Conn) http.RoundTripper{},
+		}}
+	}
+	shared := *httpClient
+	shared.Transport = newSpotifyTransport(httpClient.Transport, m)
+	httpClient = &shared
+
+	return &spotifyFactory{
+		oauth: &oauth2.Config{
+			ClientID:     clientID,
+			ClientSecret: secret,
+			RedirectURL:  redirectURI,
+			Scopes:       spotifyScopes,
+			Endpoint: oauth2.Endpoint{
+				AuthURL:  spotify.AuthURL,
+				TokenURL: spotify.TokenURL,
+			},
+		},
+		httpClient: httpClient,
+	}
+}
+
+func (f *spotifyFactory) context(ctx context.Context) context.Context {
+	return context.WithValue(ctx, oauth2.HTTPClient, f.httpClient)
+}
+
+// AuthURL is the Spotify page asking the user to grant access.
+func (f *spotifyFactory) AuthURL(state string) string {
+	return f.oauth.AuthCodeURL(state)
+}
+
+// Token checks the callback request against the expected state and
+// exchanges its code for a token, like spotify.Authenticator.Token.
+func (f *spotifyFactory) Token(ctx context.Context, state string, r *http.Request) (*oauth2.Token, error) {
+	values := r.URL.Query()
+	if e := values.Get("error"); e != "" {
+		return nil, errors.New("spotify: auth failed - " + e)
+	}
+	code := values.Get("code")
+	if code == "" {
+		return nil, errors.New("spotify: didn't get access code")
+	}
+	if values.Get("state") != state {
+		return nil, errors.New("spotify: redirect state parameter doesn't match")
+	}
+	return f.oauth.Exchange(f.context(ctx), code)
+}
+
+// NewClient returns a client acting on behalf of the token's user.
+// Expired tokens are refreshed through the same HTTP client. The client
+// may outlive ctx, as in the now-playing poller, so only its values are kept.
+func (f *spotifyFactory) NewClient(ctx context.Context, token *oauth2.Token) *spotify.Client {
+	client := spotify.NewClient(f.oauth.Client(f.context(context.WithoutCancel(ctx)), token))
+	return &client
+}
+
+// spotifyError answers a request whose Spotify call failed. Rate limits and
+// an open circuit are passed on with a Retry-After, so clients can back off.
+func (a *App) spotifyError(w http.ResponseWriter, r *http.Request, err error) {
+	var limited *rateLimitError
+	var open *circuitOpenError
+	var apiErr spotify.Error
+	switch {
+	case errors.As(err, &limited):
+		w.Header().Set("Retry-After", retryAfterSeconds(limited.retryAfter))
+		http.Error(w, "Spotify is rate limiting requests, try again in "+retryAfterSeconds(limited.retryAfter)+" seconds", http.StatusTooManyRequests)
+	case errors.As(err, &open):
+		w.Header().Set("Retry-After", retryAfterSeconds(open.retryAfter))
+		http.Error(w, "Spotify is unavailable, try again in "+retryAfterSeconds(open.retryAfter)+" seconds", http.StatusServiceUnavailable)
+	case errors.As(err, &apiErr):
+		http.Error(w, fmt.Sprintf("Spotify error: %s", apiErr.Message), http.StatusBadGateway)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// retryAfterSeconds rounds d up to whole seconds, as Retry-After wants.
+func retryAfterSeconds(d time.Duration) string {
+	return strconv.Itoa(int((d + time.Second - 1) / time.Second))
+}
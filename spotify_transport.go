@@ -0,0 +1,340 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+const (
+	spotifyGlobalRate  = 20 // requests per second, for the whole app
+	spotifyGlobalBurst = 40
+	spotifyUserRate    = 2 // requests per second, per access token
+	spotifyUserBurst   = 5
+	spotifyUserIdle    = 10 * time.Minute // per-user limiters unused for this long are dropped
+
+	// spotifyMaxWait is the longest a request waits for a rate limit
+	// before failing with a rateLimitError instead.
+	spotifyMaxWait = 5 * time.Second
+
+	spotifyMaxAttempts = 3
+	spotifyRetryBase   = 200 * time.Millisecond
+
+	breakerThreshold = 5 // consecutive failures that open the circuit
+	breakerCooldown  = 30 * time.Second
+)
+
+// rateLimitError is returned when a request would have to wait longer
+// than spotifyMaxWait, because of our limits or Spotify's Retry-After.
+type rateLimitError struct {
+	retryAfter time.Duration
+}
+
+func (e *rateLimitError) Error() string {
+	return fmt.Sprintf("spotify: rate limited, retry after %s", e.retryAfter.Round(time.Second))
+}
+
+// circuitOpenError is returned without calling Spotify while the
+// circuit breaker is open.
+type circuitOpenError struct {
+	retryAfter time.Duration
+}
+
+func (e *circuitOpenError) Error() string {
+	return fmt.Sprintf("spotify: unavailable, retry after %s", e.retryAfter.Round(time.Second))
+}
+
+// spotifyTransport sits under every Spotify client of the app. It rate
+// limits requests globally and per user, honours Spotify's Retry-After,
+// retries idempotent requests that fail with a 5xx or a network error,
+// and stops calling Spotify for a while after repeated failures.
+type spotifyTransport struct {
+	next    http.RoundTripper
+	metrics *metrics
+	global  *rate.Limiter
+
+	mutex        sync.Mutex
+	users        map[string]*userLimiter
+	blockedUntil time.Time // set from Spotify's Retry-After
+
+	breaker *breaker
+}
+
+type userLimiter struct {
+	*rate.Limiter
+	lastUsed time.Time
+}
+
+func newSpotifyTransport(next http.RoundTripper, m *metrics) *spotifyTransport {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &spotifyTransport{
+		next:    next,
+		metrics: m,
+		global:  rate.NewLimiter(spotifyGlobalRate, spotifyGlobalBurst),
+		users:   make(map[string]*userLimiter),
+		breaker: &breaker{metrics: m},
+	}
+}
+
+func (t *spotifyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.breaker.allow(); err != nil {
+		return nil, err
+	}
+	resp, reached, failed, err := t.send(req)
+	if reached {
+		t.breaker.record(!failed)
+	} else {
+		t.breaker.release()
+	}
+	return resp, err
+}
+
+// send makes up to spotifyMaxAttempts attempts of req. reached reports
+// whether any attempt got to Spotify, and failed whether the last one
+// that did ended with a 5xx or a network error, so that the breaker
+// counts a logical request once, however often it was retried.
+func (t *spotifyTransport) send(req *http.Request) (resp *http.Response, reached, failed bool, err error) {
+	for attempt := 1; ; attempt++ {
+		if err := t.wait(req); err != nil {
+			return nil, reached, failed, err
+		}
+
+		resp, err = t.next.RoundTrip(req)
+		reached = true
+		// A 429 is not a failure: Spotify is up and answering, and block
+		// already holds requests back until its Retry-After, so opening
+		// the circuit as well would only stretch the outage.
+		failed = err != nil || resp.StatusCode >= 500
+
+		if err == nil && resp.StatusCode == http.StatusTooManyRequests {
+			retryAfter := parseRetryAfter(resp)
+			t.block(retryAfter)
+			t.metrics.spotifyLimited.WithLabelValues("spotify").Inc()
+			discard(resp)
+			if attempt == spotifyMaxAttempts || retryAfter > spotifyMaxWait || !replayable(req) {
+				return nil, reached, failed, &rateLimitError{retryAfter}
+			}
+			req = rewind(req)
+			continue
+		}
+
+		if !failed || attempt == spotifyMaxAttempts || !replayable(req) {
+			return resp, reached, failed, err
+		}
+		if resp != nil {
+			discard(resp)
+		}
+		t.metrics.spotifyRetries.Inc()
+		if err := sleep(req.Context(), backoff(attempt)); err != nil {
+			return nil, reached, failed, err
+		}
+		req = rewind(req)
+	}
+}
+
+// wait blocks until Spotify's Retry-After has passed and both
+// the global and the user's limiter allow the request.
+func (t *spotifyTransport) wait(req *http.Request) error {
+	t.mutex.Lock()
+	until := t.blockedUntil
+	user := t.userLimiter(req.Header.Get("Authorization"))
+	t.mutex.Unlock()
+
+	if d := time.Until(until); d > 0 {
+		if d > spotifyMaxWait {
+			return &rateLimitError{d}
+		}
+		if err := sleep(req.Context(), d); err != nil {
+			return err
+		}
+	}
+
+	if err := t.reserve(req.Context(), t.global, "global"); err != nil {
+		return err
+	}
+	if user != nil {
+		return t.reserve(req.Context(), user.Limiter, "user")
+	}
+	return nil
+}
+
+func (t *spotifyTransport) reserve(ctx context.Context, l *rate.Limiter, limit string) error {
+	r := l.Reserve()
+	d := r.Delay()
+	if d == 0 {
+		return nil
+	}
+
+	t.metrics.spotifyLimited.WithLabelValues(limit).Inc()
+	if d > spotifyMaxWait {
+		r.Cancel()
+		return &rateLimitError{d}
+	}
+	if err := sleep(ctx, d); err != nil {
+		r.Cancel()
+		return err
+	}
+	return nil
+}
+
+// userLimiter returns the limiter of the access token in authorization,
+// or nil for requests without one (the token exchange). Tokens are only
+// kept hashed. t.mutex must be held.
+func (t *spotifyTransport) userLimiter(authorization string) *userLimiter {
+	if authorization == "" {
+		return nil
+	}
+	sum := sha256.Sum256([]byte(authorization))
+	key := hex.EncodeToString(sum[:8])
+
+	now := time.Now()
+	l, ok := t.users[key]
+	if !ok {
+		for k, u := range t.users {
+			if now.Sub(u.lastUsed) > spotifyUserIdle {
+				delete(t.users, k)
+			}
+		}
+		l = &userLimiter{Limiter: rate.NewLimiter(spotifyUserRate, spotifyUserBurst)}
+		t.users[key] = l
+	}
+	l.lastUsed = now
+	return l
+}
+
+// block holds back every request until Spotify's Retry-After has passed.
+func (t *spotifyTransport) block(d time.Duration) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if until := time.Now().Add(d); until.After(t.blockedUntil) {
+		t.blockedUntil = until
+	}
+}
+
+func parseRetryAfter(resp *http.Response) time.Duration {
+	seconds, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+	if err != nil || seconds < 0 {
+		return time.Second
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// backoff is an exponential delay with full jitter.
+func backoff(attempt int) time.Duration {
+	max := spotifyRetryBase << uint(attempt-1)
+	return time.Duration(rand.Int63n(int64(max)) + 1)
+}
+
+// replayable reports whether req is idempotent and can be sent again.
+func replayable(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+	}
+	return false
+}
+
+// rewind returns a copy of req with a fresh body, for the next attempt.
+func rewind(req *http.Request) *http.Request {
+	r := req.Clone(req.Context())
+	if req.GetBody != nil {
+		r.Body, _ = req.GetBody()
+	}
+	return r
+}
+
+// discard closes resp so its connection can be reused.
+func discard(resp *http.Response) {
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	resp.Body.Close()
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+const (
+	circuitClosed = iota
+	circuitHalfOpen
+	circuitOpen
+)
+
+// breaker opens after breakerThreshold consecutive failures. Once
+// breakerCooldown has passed it lets a single request through; the
+// circuit closes again if that request succeeds.
+type breaker struct {
+	metrics *metrics
+
+	mutex    sync.Mutex
+	state    int
+	failures int
+	openedAt time.Time
+}
+
+func (b *breaker) allow() error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	switch b.state {
+	case circuitOpen:
+		if wait := breakerCooldown - time.Since(b.openedAt); wait > 0 {
+			return &circuitOpenError{wait}
+		}
+		b.setState(circuitHalfOpen)
+		return nil
+	case circuitHalfOpen:
+		return &circuitOpenError{time.Second} // a probe is already in flight
+	}
+	return nil
+}
+
+// release ends a probe that never reached Spotify, so that the next
+// request can probe instead.
+func (b *breaker) release() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.state == circuitHalfOpen {
+		b.setState(circuitOpen)
+	}
+}
+
+func (b *breaker) record(ok bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if ok {
+		b.failures = 0
+		b.setState(circuitClosed)
+		return
+	}
+
+	b.failures++
+	if b.state == circuitHalfOpen || b.failures >= breakerThreshold {
+		b.openedAt = time.Now()
+		b.setState(circuitOpen)
+	}
+}
+
+// setState must be called with b.mutex held.
+func (b *breaker) setState(state int) {
+	b.state = state
+	b.metrics.spotifyCircuit.Set(float64(state))
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"spotify-live-lyricist/pkg/fakeSpotify"
+)
+
+// fakeToken logs userID in to the fake and returns the access token.
+func fakeToken(t *testing.T, fake *fakeSpotify.Server, userID string) string {
+	t.Helper()
+	fake.AuthorizeAs(userID)
+	c := fake.Client()
+
+	resp, err := c.Get("https://accounts.spotify.com/authorize?response_type=code&redirect_uri=http://localhost/callback")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	loc, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err = c.PostForm("https://accounts.spotify.com/api/token", url.Values{
+		"grant_type": {"authorization_code"},
+		"code":       {loc.Query().Get("code")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var tok struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tok); err != nil {
+		t.Fatal(err)
+	}
+	return tok.AccessToken
+}
+
+// attempts records the body of every attempt that reaches next.
+type attempts struct {
+	next http.RoundTripper
+
+	mutex  sync.Mutex
+	bodies []string
+}
+
+func (a *attempts) RoundTrip(req *http.Request) (*http.Response, error) {
+	// req's own body is used up, as next would have, so a replay
+	// without a rewind sends nothing
+	var body []byte
+	if req.Body != nil {
+		body, _ = io.ReadAll(req.Body)
+		req = req.Clone(req.Context())
+		req.Body = io.NopCloser(strings.NewReader(string(body)))
+	}
+	a.mutex.Lock()
+	a.bodies = append(a.bodies, string(body))
+	a.mutex.Unlock()
+	return a.next.RoundTrip(req)
+}
+
+func (a *attempts) count() int {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return len(a.bodies)
+}
+
+func newTestTransport(t *testing.T) (*spotifyTransport, *attempts, *fakeSpotify.Server) {
+	fake := fakeSpotify.New()
+	t.Cleanup(fake.Close)
+	a := &attempts{next: fake.Transport()}
+	return newSpotifyTransport(a, newMetrics()), a, fake
+}
+
+func call(tr *spotifyTransport, method, path, token, body string) (*http.Response, error) {
+	var r io.Reader
+	if body != "" {
+		r = strings.NewReader(body)
+	}
+	req, _ := http.NewRequest(method, "https://api.spotify.com"+path, r)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := tr.RoundTrip(req)
+	if err == nil {
+		discard(resp)
+	}
+	return resp, err
+}
+
+func TestTransportRetries(t *testing.T) {
+	tr, a, fake := newTestTransport(t)
+	token := fakeToken(t, fake, fakeSpotify.DefaultUser)
+
+	tests := []struct {
+		name, method, path, body string
+		failures                 int
+		status, attempts         int
+	}{
+		{"recovers", "GET", "/v1/me", "", 2, http.StatusOK, 3},
+		{"gives up", "GET", "/v1/me", "", 3, http.StatusInternalServerError, 3},
+		{"rewinds the body", "PUT", "/v1/me/player/play", `{"position_ms":0}`, 1, http.StatusNoContent, 2},
+	}
+	for _, tt := range tests {
+		a.bodies = nil
+		fake.Fail(tt.path, http.StatusInternalServerError, tt.failures)
+
+		resp, err := call(tr, tt.method, tt.path, token, tt.body)
+		if err != nil || resp.StatusCode != tt.status || a.count() != tt.attempts {
+			t.Errorf("%s: got %v, %v after %d attempts, want %d after %d", tt.name, resp, err, a.count(), tt.status, tt.attempts)
+		}
+		for i, body := range a.bodies {
+			if body != tt.body {
+				t.Errorf("%s: attempt %d sent %q", tt.name, i+1, body)
+			}
+		}
+	}
+
+	// the fake has no POST endpoints, so fail in front of it
+	posts := 0
+	tr.next = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		posts++
+		return &http.Response{StatusCode: http.StatusInternalServerError, Body: http.NoBody}, nil
+	})
+	if resp, err := call(tr, "POST", "/v1/me/player/queue", token, "{}"); err != nil || resp.StatusCode != http.StatusInternalServerError || posts != 1 {
+		t.Errorf("POST: got %v, %v after %d attempts, want no retry", resp, err, posts)
+	}
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+func TestBackoff(t *testing.T) {
+	for attempt := 1; attempt < spotifyMaxAttempts; attempt++ {
+		max := spotifyRetryBase << uint(attempt-1)
+		seen := make(map[time.Duration]bool)
+		for i := 0; i < 20; i++ {
+			d := backoff(attempt)
+			if d <= 0 || d > max {
+				t.Errorf("attempt %d: %s not in (0, %s]", attempt, d, max)
+			}
+			seen[d] = true
+		}
+		if len(seen) < 2 {
+			t.Errorf("attempt %d: no jitter in %v", attempt, seen)
+		}
+	}
+}
+
+func TestTransportRetryAfter(t *testing.T) {
+	tr, a, fake := newTestTransport(t)
+	token := fakeToken(t, fake, fakeSpotify.DefaultUser)
+
+	// a short Retry-After is waited out, and the request retried
+	fake.RateLimit("/v1/me", time.Second, 1)
+	start := time.Now()
+	if resp, err := call(tr, "GET", "/v1/me", token, ""); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("got %v, %v", resp, err)
+	}
+	if waited := time.Since(start); waited < 900*time.Millisecond || a.count() != 2 {
+		t.Errorf("waited %s over %d attempts", waited, a.count())
+	}
+
+	// a long one fails the request, and blocks the next without calling Spotify
+	a.bodies = nil
+	fake.RateLimit("/v1/me", time.Minute, 1)
+	for i := 0; i < 2; i++ {
+		var limited *rateLimitError
+		if _, err := call(tr, "GET", "/v1/me", token, ""); !errors.As(err, &limited) || limited.retryAfter <= spotifyMaxWait {
+			t.Errorf("request %d: got %v", i+1, err)
+		}
+	}
+	if a.count() != 1 {
+		t.Errorf("%d attempts reached Spotify, want 1", a.count())
+	}
+}
+
+func TestTransportLimiters(t *testing.T) {
+	tr, a, fake := newTestTransport(t)
+	alice := fakeToken(t, fake, fakeSpotify.DefaultUser)
+	bob := fakeToken(t, fake, "bob")
+	var limited *rateLimitError
+
+	// alice's limiter runs dry, bob's doesn't
+	if _, err := call(tr, "GET", "/v1/me", alice, ""); err != nil {
+		t.Fatal(err)
+	}
+	for _, u := range tr.users {
+		u.Limiter = rate.NewLimiter(rate.Every(time.Hour), 1)
+		u.Allow()
+	}
+	if _, err := call(tr, "GET", "/v1/me", alice, ""); !errors.As(err, &limited) {
+		t.Errorf("alice: got %v, want a rateLimitError", err)
+	}
+	if _, err := call(tr, "GET", "/v1/me", bob, ""); err != nil {
+		t.Errorf("bob: got %v", err)
+	}
+	if len(tr.users) != 2 {
+		t.Errorf("got %d user limiters, want 2", len(tr.users))
+	}
+
+	// idle limiters are dropped when another user shows up
+	for _, u := range tr.users {
+		u.lastUsed = time.Now().Add(-2 * spotifyUserIdle)
+	}
+	if _, err := call(tr, "GET", "/v1/me", fakeToken(t, fake, "carol"), ""); err != nil {
+		t.Fatal(err)
+	}
+	if len(tr.users) != 1 {
+		t.Errorf("got %d user limiters, want 1", len(tr.users))
+	}
+
+	// the global limiter holds everyone back
+	tr.global = rate.NewLimiter(rate.Every(time.Hour), 1)
+	tr.global.Allow()
+	a.bodies = nil
+	if _, err := call(tr, "GET", "/v1/me", bob, ""); !errors.As(err, &limited) || a.count() != 0 {
+		t.Errorf("global: got %v after %d attempts", err, a.count())
+	}
+}
+
+func TestTransportBreaker(t *testing.T) {
+	tr, a, fake := newTestTransport(t)
+	token := fakeToken(t, fake, fakeSpotify.DefaultUser)
+	reopen := func() { tr.breaker.openedAt = time.Now().Add(-breakerCooldown) }
+	var open *circuitOpenError
+
+	// retried attempts of a request count as one failure
+	fake.Fail("/v1/me", http.StatusInternalServerError, breakerThreshold*spotifyMaxAttempts)
+	for i := 0; i < breakerThreshold; i++ {
+		if tr.breaker.state != circuitClosed {
+			t.Fatalf("open after %d failures", i)
+		}
+		call(tr, "GET", "/v1/me", token, "")
+	}
+	if tr.breaker.state != circuitOpen {
+		t.Fatalf("still closed after %d failures", breakerThreshold)
+	}
+
+	a.bodies = nil
+	if _, err := call(tr, "GET", "/v1/me", token, ""); !errors.As(err, &open) || a.count() != 0 {
+		t.Errorf("open: got %v after %d attempts", err, a.count())
+	}
+
+	// after the cooldown a single probe goes through
+	reopen()
+	if err := tr.breaker.allow(); err != nil || tr.breaker.state != circuitHalfOpen {
+		t.Fatalf("probe: got %v in state %d", err, tr.breaker.state)
+	}
+	if err := tr.breaker.allow(); !errors.As(err, &open) {
+		t.Errorf("second probe: got %v", err)
+	}
+	tr.breaker.release()
+	if tr.breaker.state != circuitOpen {
+		t.Errorf("released probe left state %d", tr.breaker.state)
+	}
+
+	// a failed probe opens the circuit again, a successful one closes it
+	fake.Fail("/v1/me", http.StatusServiceUnavailable, spotifyMaxAttempts)
+	reopen()
+	if resp, err := call(tr, "GET", "/v1/me", token, ""); err != nil || resp.StatusCode != http.StatusServiceUnavailable || tr.breaker.state != circuitOpen {
+		t.Errorf("failed probe: got %v, %v in state %d", resp, err, tr.breaker.state)
+	}
+	reopen()
+	if resp, err := call(tr, "GET", "/v1/me", token, ""); err != nil || resp.StatusCode != http.StatusOK || tr.breaker.state != circuitClosed {
+		t.Errorf("probe: got %v, %v in state %d", resp, err, tr.breaker.state)
+	}
+
+	// a 429 means Spotify is up, so it resets the failures
+	tr.breaker.failures = breakerThreshold - 1
+	fake.RateLimit("/v1/me", time.Minute, 1)
+	call(tr, "GET", "/v1/me", token, "")
+	if tr.breaker.state != circuitClosed || tr.breaker.failures != 0 {
+		t.Errorf("after a 429: state %d with %d failures", tr.breaker.state, tr.breaker.failures)
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/zmb3/spotify"
+	"spotify-live-lyricist/pkg/lyricLang"
+	"spotify-live-lyricist/pkg/lyricSync"
+)
+
+const (
+	statsMinPlays     = 10 // plays below which top tracks stand in for the history
+	statsTopTracks    = 50
+	statsLookups      = 20 // tracks without stored lyrics prefetched per visit
+	statsWords        = 15
+	statsMissing      = 10
+	statsChartWidth   = 300 // px of the longest bar
+	statsChartSpacing = 22  // px between bars
+)
+
+// statsTrack is a track of the user's history with how much it was played.
+type statsTrack struct {
+	play
+	Plays    int
+	Listened int // ms
+	Lyrics   string
+	Found    bool
+}
+
+// bar is one bar of a chart, laid out by chartOf.
+type bar struct {
+	Label string
+	Value string
+	Y     int
+	Width int
+}
+
+type chart struct {
+	Bars   []bar
+	Height int
+}
+
+// userStats are the insights of /stats.
+type userStats struct {
+	FromTopTracks bool // whether Spotify's top tracks stood in for the history
+	Plays         int
+	Tracks        int
+	Hours         float64
+	Vocabulary    int
+	Explicit      float64 // share of listening time, in percent
+	Words         chart
+	Languages     chart
+	Missing       []statsTrack
+}
+
+// statsPage shows what the user listens to through the lyrics: their
+// most used words, vocabulary and languages, how much of it is explicit,
+// and the songs they play most that have no lyrics.
+func (a *App) statsPage(w http.ResponseWriter, r *http.Request) {
+	client, user, err := a.getUser(w, r)
+	if err != nil {
+		return
+	}
+	ctx := r.Context()
+
+	plays, err := a.store.History(ctx, user.ID, historyLimit)
+	if err != nil {
+		reqLogger(r).Error("Getting history", "err", err)
+		a.errors.record("store", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	fromTop := false
+	if len(plays) < statsMinPlays {
+		// too little history yet to say anything: Spotify's top tracks
+		// are months of listening, counted once each
+		top, err := a.topTracks(ctx, client)
+		if err != nil {
+			reqLogger(r).Warn("Getting top tracks", "err", err)
+			a.errors.record("spotify", err)
+		} else if len(top) > len(plays) {
+			plays, fromTop = top, true
+		}
+	}
+
+	stats := a.userStats(ctx, plays)
+	stats.FromTopTracks = fromTop
+	clean := a.cleanMode(r)
+	for i := range stats.Words.Bars {
+		b := &stats.Words.Bars[i]
+		b.Label = a.clean(clean, b.Label, lyricLang.Unknown)
+	}
+	if err := a.tpl.ExecuteTemplate(w, "stats.gohtml", stats); err != nil {
+		reqLogger(r).Error("Rendering stats", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// topTracks returns the user's most listened tracks as one play each.
+func (a *App) topTracks(ctx context.Context, client *spotify.Client) ([]play, error) {
+	limit, timerange := statsTopTracks, "medium"
+	done := a.startSpotifyCall(ctx, "CurrentUsersTopTracks")
+	page, err := client.CurrentUsersTopTracksOpt(&spotify.Options{Limit: &limit, Timerange: &timerange})
+	done(err)
+	if err != nil {
+		return nil, err
+	}
+
+	plays := make([]play, 0, len(page.Tracks))
+	for _, t := range page.Tracks {
+		p := play{TrackID: string(t.ID), Title: t.Name, Album: t.Album.Name, DurationMs: t.Duration, Explicit: t.Explicit}
+		if len(t.Artists) > 0 {
+			p.Artist = t.Artists[0].Name
+		}
+		plays = append(plays, p)
+	}
+	return plays, nil
+}
+
+// userStats computes the insights of plays. Lyrics come from the search
+// index, or else from corrections and the cache; the most played tracks
+// without any are prefetched in the background, for the next visit,
+// rather than holding up the page.
+func (a *App) userStats(ctx context.Context, plays []play) userStats {
+	var tracks []*statsTrack
+	byTrack := make(map[string]*statsTrack)
+	for _, p := range plays {
+		t, ok := byTrack[p.TrackID]
+		if !ok {
+			t = &statsTrack{play: p}
+			byTrack[p.TrackID] = t
+			tracks = append(tracks, t)
+		}
+		t.Plays++
+		t.Listened += p.DurationMs
+	}
+	sort.SliceStable(tracks, func(i, j int) bool { return tracks[i].Plays > tracks[j].Plays })
+
+	var missing []trackRef
+	for _, t := range tracks {
+		if doc, ok := a.lyrics.index.Get(t.Artist, t.Title); ok {
+			t.Lyrics, t.Found = doc.Lyrics, true
+		} else if lyrics, _, ok := a.lyrics.lookupStored(ctx, t.Artist, t.Title); ok {
+			t.Lyrics, t.Found = lyricSync.Plain(lyrics), true
+		} else if len(missing) < statsLookups {
+			missing = append(missing, trackRef{ID: spotify.ID(t.TrackID), Artist: t.Artist, Title: t.Title, Album: t.Album})
+		}
+	}
+	a.prefetch.later(ctx, missing)
+
+	s := userStats{Plays: len(plays), Tracks: len(tracks)}
+	counts := make(map[string]int)
+	vocabulary := make(map[string]bool)
+	languages := make(map[string]int)
+	total, explicit := 0, 0
+	for _, t := range tracks {
+		total += t.Listened
+		if t.Explicit {
+			explicit += t.Listened
+		}
+		if !t.Found {
+			languages[lyricLang.Unknown] += t.Listened
+			if len(s.Missing) < statsMissing {
+				s.Missing = append(s.Missing, *t)
+			}
+			continue
+		}
+		languages[lyricLang.Detect(t.Lyrics)] += t.Listened
+		for _, word := range lyricLang.Words(t.Lyrics) {
+			vocabulary[word] = true
+			// words of one or two letters, like "oh", say little
+			if len([]rune(word)) > 2 && !lyricLang.IsStopword(word) {
+				counts[word] += t.Plays
+			}
+		}
+	}
+	s.Hours = float64(total) / 3600000
+	s.Vocabulary = len(vocabulary)
+	if total > 0 {
+		s.Explicit = 100 * float64(explicit) / float64(total)
+	}
+
+	var words []bar
+	for word, n := range counts {
+		words = append(words, bar{Label: word, Value: fmt.Sprint(n)})
+	}
+	s.Words = chartOf(words, func(b bar) int { return counts[b.Label] }, statsWords)
+
+	var langs []bar
+	listened := make(map[string]int)
+	for code, ms := range languages {
+		if total == 0 {
+			break
+		}
+		name := lyricLang.Name(code)
+		listened[name] = ms
+		langs = append(langs, bar{Label: name, Value: fmt.Sprintf("%.0f%%", 100*float64(ms)/float64(total))})
+	}
+	s.Languages = chartOf(langs, func(b bar) int { return listened[b.Label] }, len(langs))
+	return s
+}
+
+// chartOf keeps the n largest bars by value, largest first and ties in
+// label order, and lays them out.
+func chartOf(bars []bar, value func(bar) int, n int) chart {
+	sort.Slice(bars, func(i, j int) bool {
+		vi, vj := value(bars[i]), value(bars[j])
+		if vi != vj {
+			return vi > vj
+		}
+		return bars[i].Label < bars[j].Label
+	})
+	if len(bars) > n {
+		bars = bars[:n]
+	}
+	if len(bars) == 0 {
+		return chart{}
+	}
+	max := value(bars[0])
+	for i := range bars {
+		bars[i].Y = i * statsChartSpacing
+		if max > 0 {
+			bars[i].Width = statsChartWidth * value(bars[i]) / max
+		}
+	}
+	return chart{Bars: bars, Height: len(bars) * statsChartSpacing}
+}
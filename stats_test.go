@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"spotify-live-lyricist/pkg/fakeSpotify"
+)
+
+func TestChartOf(t *testing.T) {
+	bars := func(labels ...string) []bar {
+		var bs []bar
+		for _, l := range labels {
+			bs = append(bs, bar{Label: l})
+		}
+		return bs
+	}
+	values := map[string]int{"a": 1, "b": 4, "c": 2, "d": 2, "z": 0}
+	value := func(b bar) int { return values[b.Label] }
+
+	tests := []struct {
+		name string
+		bars []bar
+		n    int
+		want chart
+	}{
+		{"empty", nil, 3, chart{}},
+		{"largest first, ties by label", bars("a", "d", "c", "b"), 3, chart{Height: 3 * statsChartSpacing, Bars: []bar{
+			{Label: "b", Y: 0, Width: statsChartWidth},
+			{Label: "c", Y: statsChartSpacing, Width: statsChartWidth / 2},
+			{Label: "d", Y: 2 * statsChartSpacing, Width: statsChartWidth / 2},
+		}}},
+		{"all zero", bars("z"), 3, chart{Height: statsChartSpacing, Bars: []bar{{Label: "z"}}}},
+	}
+	for _, tt := range tests {
+		if got := chartOf(tt.bars, value, tt.n); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestUserStats(t *testing.T) {
+	app, _, _ := newTestApp(t)
+	ctx := context.Background()
+
+	app.lyrics.indexLyrics("Indexed", "Song", "[00:01.00]hello darkness my old friend")
+	app.lyrics.put("Cached", "Song", "[00:01.00]darkness falls across the land", "fake")
+	song := func(id, artist string, explicit bool) play {
+		return play{TrackID: id, Artist: artist, Title: "Song", DurationMs: 60000, Explicit: explicit}
+	}
+	plays := []play{
+		song("t1", "Indexed", false), song("t1", "Indexed", false),
+		song("t2", "Cached", true),
+		song("t3", "Fetched", false), song("t3", "Fetched", false), song("t3", "Fetched", false),
+	}
+
+	s := app.userStats(ctx, plays)
+	if s.Plays != 6 || s.Tracks != 3 || s.Hours != 0.1 || s.Explicit < 16 || s.Explicit > 17 {
+		t.Errorf("got %+v", s)
+	}
+	if len(s.Missing) != 1 || s.Missing[0].Artist != "Fetched" || s.Missing[0].Plays != 3 {
+		t.Errorf("missing %+v, want the track no lyrics are stored for", s.Missing)
+	}
+	if len(s.Words.Bars) == 0 || s.Words.Bars[0].Label != "darkness" || s.Words.Bars[0].Value != "3" {
+		t.Errorf("words %+v", s.Words.Bars)
+	}
+	for _, b := range s.Words.Bars {
+		if strings.Contains(b.Label, "00") {
+			t.Errorf("time tag counted as a word: %+v", b)
+		}
+	}
+
+	// the missing track was prefetched, not fetched for the page
+	waitFor(t, func() bool { return app.lyrics.cached("Fetched", "Song") })
+	if s = app.userStats(ctx, plays); len(s.Missing) != 0 {
+		t.Errorf("still missing %+v", s.Missing)
+	}
+}
+
+func TestStatsPage(t *testing.T) {
+	app, fake, srv := newTestApp(t)
+	c := loggedIn(t, fake, srv)
+
+	_, body := get(t, c, srv.URL+"/stats")
+	if !strings.Contains(body, "Nothing played yet.") {
+		t.Errorf("empty stats:\n%s", body)
+	}
+
+	// too few plays: the top tracks stand in
+	fake.SetTopTracks(fakeSpotify.DefaultUser, fakeSpotify.Track("t1", "Artist", "Song", 3*time.Minute))
+	if _, body = get(t, c, srv.URL+"/stats"); !strings.Contains(body, "From your 1 top tracks") {
+		t.Errorf("top tracks stats:\n%s", body)
+	}
+
+	var plays []play
+	for i := 0; i < statsMinPlays; i++ {
+		plays = append(plays, play{TrackID: fmt.Sprint("t", i), Artist: "Artist", Title: fmt.Sprint("Song ", i), DurationMs: 180000, PlayedAt: time.Now().Add(time.Duration(-i) * time.Minute)})
+	}
+	if err := app.store.AddPlays(context.Background(), fakeSpotify.DefaultUser, plays); err != nil {
+		t.Fatal(err)
+	}
+	_, body = get(t, c, srv.URL+"/stats")
+	for _, want := range []string{"From 10 plays of 10 tracks, 0.5 hours", "Most played without lyrics"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("stats lack %q\n%s", want, body)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"errors"
+)
+
+// errNotFound is returned by a Store when a key does not exist or has expired.
+var errNotFound = errors.New("not found")
+
+// errModerated is returned by ModerateRevision for a revision that is
+// no longer pending, and errOutdated for one older than the approved
+// revision of its track, unless it replaces it.
+var (
+	errModerated = errors.New("revision was already moderated")
+	errOutdated  = errors.New("a newer revision is approved")
+)
+
+// Store persists sessions, OAuth states, lyric revisions and
+// listening history.
+// redisStore is used in production, memoryStore in tests and
+// local development without Redis.
+type Store interface {
+	SetSession(ctx context.Context, id string, s session) error
+	GetSession(ctx context.Context, id string) (*session, error)
+	DeleteSession(ctx context.Context, id string) error
+	SessionIDs(ctx context.Context) ([]string, error)
+	// GetSessions reads the sessions of ids at once, leaving out
+	// those that have expired.
+	GetSessions(ctx context.Context, ids []string) (map[string]session, error)
+
+	SetState(ctx context.Context, id, state string) error
+	GetState(ctx context.Context, id string) (string, error)
+	DeleteState(ctx context.Context, id string) error
+
+	AddRevision(ctx context.Context, rev revision) error
+	GetRevision(ctx context.Context, id string) (*revision, error)
+	PendingRevisions(ctx context.Context) ([]revision, error)
+	// ModerateRevision checks and stores a decision at once, so that two
+	// moderators can't both decide on a revision.
+	ModerateRevision(ctx context.Context, rev revision, replace bool) error
+	ApprovedRevision(ctx context.Context, artist, title string) (*revision, error)
+
+	// AddPlays adds plays to a user's history, keeping the latest historyLimit.
+	AddPlays(ctx context.Context, userID string, plays []play) error
+	// History returns up to limit of a user's latest plays, newest first.
+	History(ctx context.Context, userID string, limit int) ([]play, error)
+
+	Ping(ctx context.Context) error
+	Close() error
+}
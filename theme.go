@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"spotify-live-lyricist/pkg/artTheme"
+	"sync"
+	"time"
+)
+
+const (
+	themeCacheLimit   = 500 // album covers whose theme is kept
+	themeFetchTimeout = 2 * time.Second
+)
+
+// themeCache keeps the theme of every album cover seen, so that a cover
+// is only downloaded once.
+type themeCache struct {
+	mutex    sync.Mutex
+	byArt    map[string]artTheme.Theme
+	fetching map[string]bool
+}
+
+// theme returns the colours of the page of a track with the given cover,
+// or the default ones if it has none or it can't be read. A cover not
+// seen yet is downloaded in the background, and the page gets the
+// default colours until it is.
+func (a *App) theme(ctx context.Context, artURL string) artTheme.Theme {
+	if artURL == "" {
+		return artTheme.Default
+	}
+	a.themes.mutex.Lock()
+	defer a.themes.mutex.Unlock()
+	if t, ok := a.themes.byArt[artURL]; ok {
+		return t
+	}
+	if !a.themes.fetching[artURL] {
+		a.themes.fetching[artURL] = true
+		go a.fetchTheme(context.WithoutCancel(ctx), artURL)
+	}
+	return artTheme.Default
+}
+
+// fetchTheme downloads a cover and keeps its theme. A cover that can't
+// be decoded is remembered with the default theme, so it isn't fetched
+// on every page; one that couldn't be downloaded is tried again.
+func (a *App) fetchTheme(ctx context.Context, artURL string) {
+	ctx, cancel := context.WithTimeout(ctx, themeFetchTimeout)
+	defer cancel()
+
+	art, err := fetchArt(ctx, artURL)
+	var t artTheme.Theme
+	if err == nil {
+		if t, err = artTheme.Decode(art); err != nil {
+			a.logger.Warn("Decoding album art", "url", artURL, "err", err)
+			t, err = artTheme.Default, nil
+		}
+	}
+
+	a.themes.mutex.Lock()
+	defer a.themes.mutex.Unlock()
+	delete(a.themes.fetching, artURL)
+	if err != nil {
+		a.logger.Warn("Fetching album art", "url", artURL, "err", err)
+		return
+	}
+	if len(a.themes.byArt) >= themeCacheLimit {
+		a.themes.byArt = make(map[string]artTheme.Theme)
+	}
+	a.themes.byArt[artURL] = t
+}
+
+// playTime is a position in a track, shown as "3:07".
+type playTime time.Duration
+
+func (t playTime) String() string {
+	s := int(time.Duration(t) / time.Second)
+	return fmt.Sprintf("%d:%02d", s/60, s%60)
+}
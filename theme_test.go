@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"spotify-live-lyricist/pkg/artTheme"
+)
+
+func TestTheme(t *testing.T) {
+	app, _, _ := newTestApp(t)
+
+	img := image.NewRGBA(image.Rect(0, 0, 10, 10))
+	for y := 0; y < 10; y++ {
+		for x := 0; x < 10; x++ {
+			img.Set(x, y, color.RGBA{20, 30, 60, 255})
+		}
+	}
+	var cover bytes.Buffer
+	png.Encode(&cover, img)
+
+	var failing atomic.Bool
+	failing.Store(true)
+	art := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/broken.png":
+			w.Write([]byte("not an image"))
+		case failing.Load():
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		default:
+			w.Write(cover.Bytes())
+		}
+	}))
+	defer art.Close()
+
+	cached := func(url string) (artTheme.Theme, bool) {
+		app.themes.mutex.Lock()
+		defer app.themes.mutex.Unlock()
+		th, ok := app.themes.byArt[url]
+		return th, ok
+	}
+	fetched := func(url string) bool {
+		app.themes.mutex.Lock()
+		defer app.themes.mutex.Unlock()
+		return !app.themes.fetching[url]
+	}
+	ctx := context.Background()
+
+	// a cover that can't be downloaded isn't remembered
+	if th := app.theme(ctx, art.URL+"/cover.png"); th != artTheme.Default {
+		t.Errorf("first page: got %v, want the default theme", th)
+	}
+	waitFor(t, func() bool { return fetched(art.URL + "/cover.png") })
+	if _, ok := cached(art.URL + "/cover.png"); ok {
+		t.Errorf("failed download remembered")
+	}
+
+	failing.Store(false)
+	app.theme(ctx, art.URL+"/cover.png")
+	waitFor(t, func() bool { _, ok := cached(art.URL + "/cover.png"); return ok })
+	if th := app.theme(ctx, art.URL+"/cover.png"); th.Background != "#141e3c" {
+		t.Errorf("got %v from the cover", th)
+	}
+
+	// one that can't be decoded is, with the default theme
+	app.theme(ctx, art.URL+"/broken.png")
+	waitFor(t, func() bool { _, ok := cached(art.URL + "/broken.png"); return ok })
+	if th, _ := cached(art.URL + "/broken.png"); th != artTheme.Default {
+		t.Errorf("got %v for a broken cover", th)
+	}
+}